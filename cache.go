@@ -0,0 +1,226 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"unicode"
+
+	"github.com/modest-sql/common"
+	"github.com/modest-sql/data"
+	"github.com/modest-sql/engine/executor"
+)
+
+//resultCache keeps the rows of read-only queries per database until a command modifies that database.
+//Once it holds maxEntries queries, storing another evicts the least recently used one.
+type resultCache struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    map[*data.Database]map[string]*list.Element
+	order      *list.List
+
+	//generations stamps each database's entries, a fill is only stored while its database keeps the stamp it
+	//started with. Stamps come from clock, so a database that is forgotten never gets an earlier stamp back.
+	generations map[*data.Database]int64
+	clock       int64
+
+	hits   int64
+	misses int64
+}
+
+//cacheEntry is the value of every element of the cache's recency order
+type cacheEntry struct {
+	db    *data.Database
+	query string
+	rows  []interface{}
+}
+
+type resultCacheStats struct {
+	Hits    int64 `json:"Hits"`
+	Misses  int64 `json:"Misses"`
+	Entries int   `json:"Entries"`
+}
+
+//cacheFill collects the rows of every SELECT in a query and stores them once all have succeeded
+type cacheFill struct {
+	cache      *resultCache
+	db         *data.Database
+	query      string
+	generation int64
+
+	mutex     sync.Mutex
+	rows      []interface{}
+	remaining int
+	failed    bool
+}
+
+//newResultCache creates a cache holding at most maxEntries queries, disabled when maxEntries is 0
+func newResultCache(maxEntries int) *resultCache {
+	return &resultCache{
+		maxEntries:  maxEntries,
+		entries:     make(map[*data.Database]map[string]*list.Element),
+		order:       list.New(),
+		generations: make(map[*data.Database]int64),
+	}
+}
+
+//normalizeQuery collapses whitespace outside quoted literals and identifiers so formatting differences share
+//a cache entry, while queries differing only inside a literal such as 'x  y' keep separate entries
+func normalizeQuery(query string) string {
+	normalized := make([]rune, 0, len(query))
+	var quote rune
+	pendingSpace := false
+	for _, r := range query {
+		if quote == 0 && unicode.IsSpace(r) {
+			pendingSpace = len(normalized) > 0
+			continue
+		}
+		if pendingSpace {
+			normalized = append(normalized, ' ')
+			pendingSpace = false
+		}
+		//An escaped quote ('') closes and reopens the literal, leaving its content untouched either way
+		switch {
+		case quote == 0 && (r == '\'' || r == '"'):
+			quote = r
+		case r == quote:
+			quote = 0
+		}
+		normalized = append(normalized, r)
+	}
+	return string(normalized)
+}
+
+func (c *resultCache) enabled() bool {
+	return c.maxEntries > 0
+}
+
+//get returns the cached rows of each SELECT in query
+func (c *resultCache) get(db *data.Database, query string) ([]interface{}, bool) {
+	if !c.enabled() {
+		return nil, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[db][normalizeQuery(query)]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry).rows, true
+}
+
+//fill starts collecting the results of query, returning nil when it can't be cached
+func (c *resultCache) fill(db *data.Database, query string, commands []common.Command) *cacheFill {
+	if !c.enabled() || len(commands) == 0 {
+		return nil
+	}
+	for _, command := range commands {
+		if _, isSelect := command.(*common.SelectTableCommand); !isSelect {
+			return nil
+		}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.generations[db]; !ok {
+		c.stampLocked(db)
+	}
+	return &cacheFill{
+		cache:      c,
+		db:         db,
+		query:      normalizeQuery(query),
+		generation: c.generations[db],
+		rows:       make([]interface{}, len(commands)),
+		remaining:  len(commands),
+	}
+}
+
+//invalidate drops every cached query of db. Fills started before the call are discarded.
+func (c *resultCache) invalidate(db *data.Database) {
+	if !c.enabled() {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.dropLocked(db)
+	c.stampLocked(db)
+}
+
+//forget removes all state kept for a database that has been unloaded. Fills still running for it are discarded.
+func (c *resultCache) forget(db *data.Database) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.dropLocked(db)
+	delete(c.generations, db)
+}
+
+//stampLocked gives db a generation no fill has started with yet, the caller must hold the mutex
+func (c *resultCache) stampLocked(db *data.Database) {
+	c.clock++
+	c.generations[db] = c.clock
+}
+
+//dropLocked removes the cached queries of db, the caller must hold the mutex
+func (c *resultCache) dropLocked(db *data.Database) {
+	for _, element := range c.entries[db] {
+		c.order.Remove(element)
+	}
+	delete(c.entries, db)
+}
+
+func (c *resultCache) stats() resultCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return resultCacheStats{Hits: c.hits, Misses: c.misses, Entries: c.size()}
+}
+
+//size counts the cached queries, the caller must hold the mutex
+func (c *resultCache) size() int {
+	return c.order.Len()
+}
+
+//record stores the outcome of the index-th SELECT. It is safe to call on a nil fill.
+func (f *cacheFill) record(index int, rows interface{}, err error) {
+	if f == nil {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.rows[index] = rows
+	f.failed = f.failed || err != nil
+	f.remaining--
+	if f.remaining > 0 || f.failed {
+		return
+	}
+
+	c := f.cache
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation, ok := c.generations[f.db]; !ok || generation != f.generation {
+		return
+	}
+	if element, ok := c.entries[f.db][f.query]; ok {
+		element.Value.(*cacheEntry).rows = f.rows
+		c.order.MoveToFront(element)
+		return
+	}
+	if c.entries[f.db] == nil {
+		c.entries[f.db] = make(map[string]*list.Element)
+	}
+	c.entries[f.db][f.query] = c.order.PushFront(&cacheEntry{db: f.db, query: f.query, rows: f.rows})
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Remove(c.order.Back()).(*cacheEntry)
+		delete(c.entries[oldest.db], oldest.query)
+		if len(c.entries[oldest.db]) == 0 {
+			delete(c.entries, oldest.db)
+		}
+	}
+}
+
+//invalidateOnWrite drops the cached results of db once a command that modifies it completes
+func invalidateOnWrite(db *data.Database, command common.Command, err error) {
+	if !executor.IsSelect(command) {
+		queryCache.invalidate(db)
+	}
+}
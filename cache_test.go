@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/modest-sql/common"
+	"github.com/modest-sql/data"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT a FROM t;", "SELECT a FROM t;"},
+		{"  SELECT\ta\n\nFROM   t;  ", "SELECT a FROM t;"},
+		{"SELECT a FROM t WHERE b = 'x  y';", "SELECT a FROM t WHERE b = 'x  y';"},
+		{"SELECT a FROM t WHERE b = 'x\ty';", "SELECT a FROM t WHERE b = 'x\ty';"},
+		{`SELECT "a  b" FROM t;`, `SELECT "a  b" FROM t;`},
+		{"SELECT a FROM t WHERE b = 'it''s  here'   ;", "SELECT a FROM t WHERE b = 'it''s  here' ;"},
+		{"SELECT a FROM t WHERE b = 'it''s'  AND  c = 1;", "SELECT a FROM t WHERE b = 'it''s' AND c = 1;"},
+	}
+	for _, test := range tests {
+		if got := normalizeQuery(test.query); got != test.want {
+			t.Errorf("normalizeQuery(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+//fillQuery caches rows as the result of query, a single SELECT on db
+func fillQuery(c *resultCache, db *data.Database, query string, rows interface{}) {
+	c.fill(db, query, []common.Command{&common.SelectTableCommand{}}).record(0, rows, nil)
+}
+
+func TestResultCacheGet(t *testing.T) {
+	c := newResultCache(10)
+	db := &data.Database{}
+	fillQuery(c, db, "SELECT a FROM t;", "rows")
+
+	rows, ok := c.get(db, "SELECT  a\nFROM t;")
+	if !ok || len(rows) != 1 || rows[0] != "rows" {
+		t.Errorf("get after fill = %v, %v, want [rows], true", rows, ok)
+	}
+	if _, ok := c.get(db, "SELECT b FROM t;"); ok {
+		t.Error("get of another query hit the cache")
+	}
+	if stats := c.stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("stats() = %+v, want 1 hit, 1 miss and 1 entry", stats)
+	}
+}
+
+func TestResultCacheDiscardsStaleFills(t *testing.T) {
+	tests := []struct {
+		name   string
+		before func(c *resultCache, db *data.Database)
+	}{
+		{"invalidate", func(c *resultCache, db *data.Database) { c.invalidate(db) }},
+		{"forget", func(c *resultCache, db *data.Database) { c.forget(db) }},
+		{"forget after invalidate", func(c *resultCache, db *data.Database) {
+			c.invalidate(db)
+			c.forget(db)
+		}},
+	}
+	for _, test := range tests {
+		c := newResultCache(10)
+		db := &data.Database{}
+		fill := c.fill(db, "SELECT a FROM t;", []common.Command{&common.SelectTableCommand{}})
+		test.before(c, db)
+		fill.record(0, "rows", nil)
+
+		if _, ok := c.get(db, "SELECT a FROM t;"); ok {
+			t.Errorf("%s: a fill started before %[1]s was stored", test.name)
+		}
+		if size := c.stats().Entries; size != 0 {
+			t.Errorf("%s: cache holds %d entries, want 0", test.name, size)
+		}
+	}
+}
+
+func TestResultCacheDiscardsFailedFills(t *testing.T) {
+	c := newResultCache(10)
+	db := &data.Database{}
+	commands := []common.Command{&common.SelectTableCommand{}, &common.SelectTableCommand{}}
+
+	fill := c.fill(db, "SELECT a FROM t; SELECT b FROM t;", commands)
+	fill.record(0, "rows", nil)
+	fill.record(1, nil, errQueryTimeout)
+	if _, ok := c.get(db, "SELECT a FROM t; SELECT b FROM t;"); ok {
+		t.Error("a query with a failed SELECT was cached")
+	}
+	if fill := c.fill(db, "INSERT INTO t VALUES (1);", []common.Command{&common.InsertCommand{}}); fill != nil {
+		t.Error("fill of a query that writes = non-nil, want nil")
+	}
+}
+
+func TestResultCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newResultCache(2)
+	first, second := &data.Database{}, &data.Database{}
+	fillQuery(c, first, "SELECT a FROM t;", "a")
+	fillQuery(c, second, "SELECT b FROM t;", "b")
+	c.get(first, "SELECT a FROM t;")
+	fillQuery(c, first, "SELECT c FROM t;", "c")
+
+	if _, ok := c.get(second, "SELECT b FROM t;"); ok {
+		t.Error("least recently used query wasn't evicted")
+	}
+	for _, query := range []string{"SELECT a FROM t;", "SELECT c FROM t;"} {
+		if _, ok := c.get(first, query); !ok {
+			t.Errorf("%q was evicted", query)
+		}
+	}
+	if size := c.stats().Entries; size != 2 {
+		t.Errorf("cache holds %d entries, want 2", size)
+	}
+}
+
+func TestResultCacheDisabled(t *testing.T) {
+	c := newResultCache(0)
+	db := &data.Database{}
+	if fill := c.fill(db, "SELECT a FROM t;", []common.Command{&common.SelectTableCommand{}}); fill != nil {
+		t.Error("fill on a disabled cache = non-nil, want nil")
+	}
+	if _, ok := c.get(db, "SELECT a FROM t;"); ok {
+		t.Error("get on a disabled cache hit")
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+type engineError struct {
+	Code    string
+	Class   string
+	Message string
+}
+
+type queryResult struct {
+	Rows            interface{}
+	RowCount        int
+	ExecutionTimeMs int64
+}
+
+type statementResult struct {
+	Result *queryResult
+	Error  *engineError
+}
+
+type client struct {
+	baseURL  string
+	database string
+	http     *http.Client
+}
+
+//do sends a request to the engine and decodes a successful JSON response into out
+func (c *client) do(method string, path string, query url.Values, body io.Reader, out interface{}) error {
+	request, err := http.NewRequest(method, c.baseURL+path+"?"+query.Encode(), body)
+	if err != nil {
+		return err
+	}
+	//The engine rejects state-changing requests without this header to keep browsers on other sites out
+	request.Header.Set("X-Modest-SQL-Client", "modest-sql-cli")
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		var engineErr engineError
+		if err := json.NewDecoder(response.Body).Decode(&engineErr); err != nil {
+			return fmt.Errorf("%s", response.Status)
+		}
+		return fmt.Errorf("ERROR %s (%s): %s", engineErr.Code, engineErr.Class, engineErr.Message)
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+func (c *client) query(statement string) error {
+	var response struct{ Results []statementResult }
+	err := c.do(http.MethodPost, "/query", url.Values{"database": {c.database}}, strings.NewReader(statement), &response)
+	if err != nil {
+		return err
+	}
+	for _, result := range response.Results {
+		switch {
+		case result.Error != nil:
+			fmt.Printf("ERROR %s (%s): %s\n", result.Error.Code, result.Error.Class, result.Error.Message)
+		case result.Result != nil:
+			printRows(result.Result.Rows)
+			fmt.Printf("(%d rows, %d ms)\n", result.Result.RowCount, result.Result.ExecutionTimeMs)
+		default:
+			fmt.Println("OK")
+		}
+	}
+	return nil
+}
+
+//printRows renders rows as a table when they are a list of objects, and as indented JSON otherwise
+func printRows(rows interface{}) {
+	list, ok := rows.([]interface{})
+	if !ok {
+		printJSON(rows)
+		return
+	}
+
+	columnSet := make(map[string]bool)
+	objects := make([]map[string]interface{}, 0, len(list))
+	for _, row := range list {
+		object, ok := row.(map[string]interface{})
+		if !ok {
+			printJSON(rows)
+			return
+		}
+		for column := range object {
+			columnSet[column] = true
+		}
+		objects = append(objects, object)
+	}
+	columns := make([]string, 0, len(columnSet))
+	for column := range columnSet {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(columns, "\t"))
+	for _, object := range objects {
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			if value, ok := object[column]; ok && value != nil {
+				values[i] = fmt.Sprint(value)
+			}
+		}
+		fmt.Fprintln(writer, strings.Join(values, "\t"))
+	}
+	writer.Flush()
+}
+
+func printJSON(value interface{}) {
+	raw, _ := json.MarshalIndent(value, "", "  ")
+	fmt.Println(string(raw))
+}
+
+//metaCommand handles backslash shortcuts, returning true when the REPL should exit
+func (c *client) metaCommand(line string) (bool, error) {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case `\q`:
+		return true, nil
+	case `\c`:
+		if len(fields) != 2 {
+			return false, fmt.Errorf(`usage: \c database`)
+		}
+		c.database = fields[1]
+		fmt.Println("Using database", c.database)
+	case `\l`:
+		var response struct{ Databases []string }
+		if err := c.do(http.MethodGet, "/databases", url.Values{}, nil, &response); err != nil {
+			return false, err
+		}
+		for _, name := range response.Databases {
+			fmt.Println(name)
+		}
+	case `\d`:
+		var response struct {
+			Databases []struct {
+				DatabaseName string `json:"DB_Name"`
+				Tables       []map[string]interface{}
+			}
+		}
+		if err := c.do(http.MethodGet, "/metadata", url.Values{}, nil, &response); err != nil {
+			return false, err
+		}
+		for _, database := range response.Databases {
+			if database.DatabaseName != c.database {
+				continue
+			}
+			for _, table := range database.Tables {
+				if len(fields) == 1 {
+					fmt.Println(table["TableName"])
+				} else if table["TableName"] == fields[1] {
+					printJSON(table)
+				}
+			}
+		}
+	case `\?`:
+		fmt.Println(`\c database  use database`)
+		fmt.Println(`\l           list databases`)
+		fmt.Println(`\d [table]   list tables or describe a table`)
+		fmt.Println(`\q           quit`)
+	default:
+		return false, fmt.Errorf(`unknown command %s, try \?`, fields[0])
+	}
+	return false, nil
+}
+
+//loadTLSConfig trusts the CA in caFile in addition to the system roots and presents the client certificate
+//in certFile and keyFile, as required by engines configured with TLSClientCAFile
+func loadTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if caFile != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		raw, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		if !roots.AppendCertsFromPEM(raw) {
+			return nil, errors.New("no valid certificates found in " + caFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("-cert and -key must be given together")
+	}
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+func main() {
+	host := flag.String("host", "localhost", "engine host")
+	port := flag.String("port", "8080", "engine HTTP port")
+	database := flag.String("database", "", "database to use")
+	secure := flag.Bool("tls", false, "connect using HTTPS")
+	caFile := flag.String("cacert", "", "PEM file with the CA that signed the engine's certificate, implies -tls")
+	certFile := flag.String("cert", "", "PEM client certificate, implies -tls")
+	keyFile := flag.String("key", "", "PEM key of the client certificate")
+	flag.Parse()
+
+	scheme := "http"
+	httpClient := &http.Client{}
+	if *secure || *caFile != "" || *certFile != "" || *keyFile != "" {
+		tlsConfig, err := loadTLSConfig(*caFile, *certFile, *keyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		scheme = "https"
+		httpClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	c := &client{baseURL: scheme + "://" + *host + ":" + *port, database: *database, http: httpClient}
+
+	input := bufio.NewScanner(os.Stdin)
+	input.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	prompt := "modest-sql> "
+
+	var statement bytes.Buffer
+	for {
+		fmt.Print(prompt)
+		if !input.Scan() {
+			fmt.Println()
+			return
+		}
+
+		line := strings.TrimSpace(input.Text())
+		if statement.Len() == 0 && line == "" {
+			continue
+		}
+		if statement.Len() == 0 && strings.HasPrefix(line, `\`) {
+			quit, err := c.metaCommand(line)
+			if err != nil {
+				fmt.Println(err)
+			}
+			if quit {
+				return
+			}
+			continue
+		}
+
+		statement.WriteString(line)
+		statement.WriteString("\n")
+		if !strings.HasSuffix(line, ";") {
+			prompt = "         -> "
+			continue
+		}
+
+		if err := c.query(statement.String()); err != nil {
+			fmt.Println(err)
+		}
+		statement.Reset()
+		prompt = "modest-sql> "
+	}
+}
@@ -0,0 +1,110 @@
+//Package embedded runs the engine in-process, without the network layer
+package embedded
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/modest-sql/data"
+	"github.com/modest-sql/engine/executor"
+	"github.com/modest-sql/parser"
+	"github.com/modest-sql/transaction"
+)
+
+var startTransactionManager sync.Once
+
+//DB is a database opened in-process
+type DB struct {
+	database *data.Database
+}
+
+//Result is the outcome of a single statement
+type Result struct {
+	//Rows holds the rows returned by a SELECT, nil for any other statement
+	Rows interface{}
+	Err  error
+}
+
+func start() {
+	startTransactionManager.Do(func() {
+		go transaction.StartTransactionManager()
+	})
+}
+
+//Create creates a new database file at path
+func Create(path string, blockSize int64) (*DB, error) {
+	start()
+	database, err := data.NewDatabase(path, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{database: database}, nil
+}
+
+//Open loads an existing database file from path
+func Open(path string) (*DB, error) {
+	start()
+	database, err := data.LoadDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{database: database}, nil
+}
+
+//Run parses and runs query, blocking until every statement has completed
+func (db *DB) Run(query string) ([]Result, error) {
+	commands, err := parser.Parse(bytes.NewReader([]byte(query)))
+	if err != nil {
+		return nil, err
+	}
+
+	outcomes := executor.Executor{}.Run(db.database, commands, nil)
+	results := make([]Result, len(outcomes))
+	for i, outcome := range outcomes {
+		results[i] = Result{Rows: outcome.Rows, Err: outcome.Err}
+	}
+	return results, nil
+}
+
+//Exec runs query and returns the first statement error
+func (db *DB) Exec(query string) error {
+	results, err := db.Run(query)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			return result.Err
+		}
+	}
+	return nil
+}
+
+//Query runs query and returns the rows of each SELECT statement in order
+func (db *DB) Query(query string) ([]interface{}, error) {
+	results, err := db.Run(query)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]interface{}, 0)
+	for _, result := range results {
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		if result.Rows != nil {
+			rows = append(rows, result.Rows)
+		}
+	}
+	return rows, nil
+}
+
+//Tables returns the definitions of every table in the database
+func (db *DB) Tables() []*data.Table {
+	return db.database.AllTables()
+}
+
+//Close releases the database. data.Database has no close or flush method, so Close doesn't flush anything
+//and relies on the data layer having written every completed statement to the file.
+func (db *DB) Close() error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/modest-sql/engine/executor"
+	"github.com/modest-sql/network"
+)
+
+//SQLSTATE-style error classes reported alongside every error code
+const (
+	classConnection      = "08"
+	classInvalidState    = "25"
+	classInvalidCatalog  = "3D"
+	classSyntaxOrAccess  = "42"
+	classObjectState     = "55"
+	classOperatorAborted = "57"
+	classSystem          = "58"
+	classInternal        = "XX"
+)
+
+//engineError is the typed error sent back to clients in every network.Error response
+type engineError struct {
+	Code    string `json:"Code"`
+	Class   string `json:"Class"`
+	Message string `json:"Message"`
+}
+
+func (e *engineError) Error() string {
+	return e.Message
+}
+
+func newEngineError(code string, class string, message string) *engineError {
+	return &engineError{Code: code, Class: class, Message: message}
+}
+
+//knownError returns the engineError err already is or stands for
+func knownError(err error) (*engineError, bool) {
+	if engineErr, ok := err.(*engineError); ok {
+		return engineErr, true
+	}
+	if err == executor.ErrTimeout {
+		return errQueryTimeout, true
+	}
+	return nil, false
+}
+
+//wrapError gives an untyped error from another package a code and class
+func wrapError(code string, class string, err error) *engineError {
+	if engineErr, ok := knownError(err); ok {
+		return engineErr
+	}
+	return newEngineError(code, class, err.Error())
+}
+
+var (
+	errDatabaseNotFound    = newEngineError("DatabaseNotFound", classInvalidCatalog, "Database isn't loaded or doesn't exist")
+	errNoActiveDatabase    = newEngineError("NoActiveDatabase", classInvalidCatalog, "No active database selected")
+	errSessionNotPaired    = newEngineError("SessionNotPaired", classConnection, "Session isn't paired with a database")
+	errQueryTimeout        = newEngineError("QueryTimeout", classOperatorAborted, "Query exceeded the configured timeout")
+	errReadOnly            = newEngineError("ReadOnly", classInvalidState, "Server is running in read-only mode")
+	errDatabaseReadOnly    = newEngineError("DatabaseReadOnly", classInvalidState, "Database is read-only")
+	errSessionReadOnly     = newEngineError("SessionReadOnly", classInvalidState, "Session is paired with the database read-only")
+	errDatabaseInUse       = newEngineError("DatabaseInUse", classObjectState, "Database is in use by another session")
+	errDatabaseExists      = newEngineError("DatabaseExists", classSyntaxOrAccess, "A database with that name already exists")
+	errTablespaceNotFound  = newEngineError("TablespaceNotFound", classInvalidCatalog, "Tablespace isn't configured")
+	errInvalidDatabaseName = newEngineError("InvalidDatabaseName", classSyntaxOrAccess, "Database names must be 1 to 64 letters, digits, '_', '-' or '.', not starting with '.'")
+)
+
+//toEngineError classifies errors that don't carry a code yet
+func toEngineError(err error) *engineError {
+	if engineErr, ok := knownError(err); ok {
+		return engineErr
+	}
+	if _, isPathError := err.(*os.PathError); isPathError {
+		return newEngineError("IOError", classSystem, err.Error())
+	}
+	return newEngineError("InternalError", classInternal, err.Error())
+}
+
+//encodeError marshals err as an engineError
+func encodeError(err error) string {
+	raw, _ := json.Marshal(toEngineError(err))
+	return string(raw)
+}
+
+//sendError sends err to the session as a network.Error response
+func sendError(server *network.Server, sessionID int64, err error) {
+	server.Send(sessionID, network.Response{Type: network.Error, Data: encodeError(err)})
+}
@@ -0,0 +1,114 @@
+//Package executor runs parsed commands against a database through the transaction manager. It is shared by
+//the engine's network, HTTP and script front ends and by the embedded package.
+package executor
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/modest-sql/common"
+	"github.com/modest-sql/data"
+	"github.com/modest-sql/transaction"
+)
+
+//ErrTimeout is reported for a SELECT that doesn't complete within the executor's timeout
+var ErrTimeout = errors.New("Query exceeded the configured timeout")
+
+//bind and queue hand commands to the data layer and the transaction manager, tests replace them
+var (
+	bind = func(db *data.Database, command common.Command, callback func(interface{}, error)) common.Command {
+		return db.CommandFactory(command, callback)
+	}
+	queue = transaction.AddCommands
+)
+
+//Executor binds commands to a database and queues them with the transaction manager.
+//The zero value runs commands without a timeout.
+type Executor struct {
+	//Timeout bounds how long a SELECT may take, zero disables it. Queued commands can't be cancelled, so writes
+	//aren't timed out: the caller would be told a write failed that still commits later.
+	Timeout time.Duration
+
+	//Completed is called, when set, once a command has actually completed and before its callback
+	Completed func(db *data.Database, command common.Command, err error)
+}
+
+//Result is the outcome of a single command
+type Result struct {
+	//Rows holds the rows returned by a SELECT, nil for any other command
+	Rows    interface{}
+	Err     error
+	Elapsed time.Duration
+}
+
+//IsSelect reports whether command only reads from the database
+func IsSelect(command common.Command) bool {
+	_, isSelect := command.(*common.SelectTableCommand)
+	return isSelect
+}
+
+//Prepare binds command and its callback to db. The callback may be nil.
+func (e Executor) Prepare(db *data.Database, command common.Command, callback func(interface{}, error)) common.Command {
+	function := callback
+	if IsSelect(command) {
+		function = withTimeout(e.Timeout, function)
+	}
+	if e.Completed != nil {
+		next := function
+		function = func(result interface{}, err error) {
+			e.Completed(db, command, err)
+			if next != nil {
+				next(result, err)
+			}
+		}
+	}
+	if function == nil {
+		function = func(interface{}, error) {}
+	}
+	return bind(db, command, function)
+}
+
+//Run queues commands against db and blocks until every one has completed, returning their results in order.
+//observe, when not nil, is called with each result as soon as its command completes.
+func (e Executor) Run(db *data.Database, commands []common.Command, observe func(index int, result Result)) []Result {
+	results := make([]Result, len(commands))
+	prepared := make([]common.Command, 0, len(commands))
+	var wg sync.WaitGroup
+	wg.Add(len(commands))
+	for i, command := range commands {
+		index, isSelect, start := i, IsSelect(command), time.Now()
+		prepared = append(prepared, e.Prepare(db, command, func(rows interface{}, err error) {
+			defer wg.Done()
+			results[index] = Result{Err: err, Elapsed: time.Since(start)}
+			if err == nil && isSelect {
+				results[index].Rows = rows
+			}
+			if observe != nil {
+				observe(index, results[index])
+			}
+		}))
+	}
+
+	queue(prepared)
+	wg.Wait()
+	return results
+}
+
+//withTimeout wraps a command callback so that ErrTimeout is reported when the command doesn't finish in time.
+//Whichever of the result and the timeout arrives last is discarded.
+func withTimeout(timeout time.Duration, callback func(interface{}, error)) func(interface{}, error) {
+	if timeout <= 0 || callback == nil {
+		return callback
+	}
+
+	var once sync.Once
+	timer := time.AfterFunc(timeout, func() {
+		once.Do(func() { callback(nil, ErrTimeout) })
+	})
+
+	return func(result interface{}, err error) {
+		timer.Stop()
+		once.Do(func() { callback(result, err) })
+	}
+}
@@ -0,0 +1,159 @@
+package executor
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modest-sql/common"
+	"github.com/modest-sql/data"
+)
+
+//boundCommand is what the replaced bind returns in place of a data layer command
+type boundCommand struct {
+	command  common.Command
+	callback func(interface{}, error)
+}
+
+//fakeDataLayer replaces bind and queue until the returned function is called. Queued commands complete in order
+//with the rows and errors stored under the original command.
+func fakeDataLayer(rows map[common.Command]interface{}, errs map[common.Command]error) (restore func()) {
+	previousBind, previousQueue := bind, queue
+	bind = func(db *data.Database, command common.Command, callback func(interface{}, error)) common.Command {
+		return &boundCommand{command: command, callback: callback}
+	}
+	queue = func(commands []common.Command) {
+		for _, command := range commands {
+			bound := command.(*boundCommand)
+			go bound.callback(rows[bound.command], errs[bound.command])
+		}
+	}
+	return func() { bind, queue = previousBind, previousQueue }
+}
+
+type callbackResult struct {
+	result interface{}
+	err    error
+}
+
+func recordCallback(results chan<- callbackResult) func(interface{}, error) {
+	return func(result interface{}, err error) { results <- callbackResult{result, err} }
+}
+
+func TestWithTimeout(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+	failure := errors.New("table not found")
+	tests := []struct {
+		name       string
+		delay      time.Duration
+		err        error
+		wantResult interface{}
+		wantErr    error
+	}{
+		{"result first", 0, nil, "rows", nil},
+		{"error first", 0, failure, "rows", failure},
+		{"timeout first", 4 * timeout, nil, nil, ErrTimeout},
+	}
+	for _, test := range tests {
+		results := make(chan callbackResult, 2)
+		callback := withTimeout(timeout, recordCallback(results))
+		time.Sleep(test.delay)
+		callback("rows", test.err)
+
+		got := <-results
+		if got.result != test.wantResult || got.err != test.wantErr {
+			t.Errorf("%s: callback got %v, %v, want %v, %v", test.name, got.result, got.err, test.wantResult, test.wantErr)
+		}
+		select {
+		case extra := <-results:
+			t.Errorf("%s: callback called again with %v, %v", test.name, extra.result, extra.err)
+		case <-time.After(2 * timeout):
+		}
+	}
+}
+
+func TestWithTimeoutDisabled(t *testing.T) {
+	if withTimeout(time.Second, nil) != nil {
+		t.Error("withTimeout(time.Second, nil) != nil")
+	}
+	results := make(chan callbackResult, 1)
+	withTimeout(0, recordCallback(results))("rows", nil)
+	if got := <-results; got.result != "rows" || got.err != nil {
+		t.Errorf("callback without a timeout got %v, %v, want rows, nil", got.result, got.err)
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	defer fakeDataLayer(nil, nil)()
+	db := &data.Database{}
+	selectCommand, insertCommand := &common.SelectTableCommand{}, &common.InsertCommand{}
+
+	completed := make(chan common.Command, 2)
+	e := Executor{
+		Timeout:   10 * time.Millisecond,
+		Completed: func(db *data.Database, command common.Command, err error) { completed <- command },
+	}
+
+	//A nil callback still gets a function the data layer can call
+	bound := e.Prepare(db, insertCommand, nil).(*boundCommand)
+	bound.callback(nil, nil)
+	if command := <-completed; command != insertCommand {
+		t.Errorf("Completed got %v, want the insert", command)
+	}
+	if bound := (Executor{}).Prepare(db, insertCommand, nil).(*boundCommand); bound.callback == nil {
+		t.Error("Prepare with a nil callback bound a nil function")
+	} else {
+		bound.callback(nil, nil)
+	}
+
+	//A SELECT that times out is reported to the callback but only completes when the data layer is done
+	results := make(chan callbackResult, 2)
+	bound = e.Prepare(db, selectCommand, recordCallback(results)).(*boundCommand)
+	if got := <-results; got.err != ErrTimeout {
+		t.Errorf("SELECT callback got error %v, want %v", got.err, ErrTimeout)
+	}
+	select {
+	case <-completed:
+		t.Error("Completed called before the SELECT completed")
+	default:
+	}
+	bound.callback("rows", nil)
+	if command := <-completed; command != selectCommand {
+		t.Errorf("Completed got %v, want the SELECT", command)
+	}
+
+	//Writes aren't timed out
+	bound = e.Prepare(db, insertCommand, recordCallback(results)).(*boundCommand)
+	time.Sleep(3 * e.Timeout)
+	bound.callback(nil, nil)
+	if got := <-results; got.err != nil {
+		t.Errorf("insert callback got error %v, want nil", got.err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	selectCommand, insertCommand, failing := &common.SelectTableCommand{}, &common.InsertCommand{}, &common.DeleteCommand{}
+	failure := errors.New("table not found")
+	defer fakeDataLayer(
+		map[common.Command]interface{}{selectCommand: "rows", insertCommand: "ignored"},
+		map[common.Command]error{failing: failure},
+	)()
+
+	observed := make(chan int, 3)
+	results := Executor{}.Run(&data.Database{}, []common.Command{selectCommand, insertCommand, failing}, func(index int, result Result) {
+		observed <- index
+	})
+
+	want := []Result{{Rows: "rows"}, {}, {Err: failure}}
+	if len(results) != len(want) {
+		t.Fatalf("Run returned %d results, want %d", len(results), len(want))
+	}
+	for i, result := range results {
+		if result.Rows != want[i].Rows || result.Err != want[i].Err {
+			t.Errorf("result %d = %v, %v, want %v, %v", i, result.Rows, result.Err, want[i].Rows, want[i].Err)
+		}
+	}
+	if len(observed) != len(want) {
+		t.Errorf("observe called %d times, want %d", len(observed), len(want))
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+//maxQueryBytes limits the size of a /query request body
+const maxQueryBytes = 1 << 20
+
+type databasesResponse struct {
+	Databases []string `json:"Databases"`
+}
+
+type notificationResponse struct {
+	Message string `json:"Message"`
+}
+
+type queryResponse struct {
+	Results []statementResult `json:"Results"`
+}
+
+//clientHeader must be set on every request that isn't a GET. Browsers only send a custom header cross-origin after
+//a CORS preflight, which the engine never approves, so other sites can't make a visitor's browser change state here.
+const clientHeader = "X-Modest-SQL-Client"
+
+var errMissingDatabaseName = newEngineError("MissingParameter", classSyntaxOrAccess, "The name parameter is required")
+var errMethodNotAllowed = newEngineError("MethodNotAllowed", classSyntaxOrAccess, "Method not allowed")
+var errMissingClientHeader = newEngineError("MissingClientHeader", classSyntaxOrAccess, "Requests other than GET must set the "+clientHeader+" header")
+
+//startHTTPServer serves the REST API on address, using TLS when tlsConfig is not nil
+func startHTTPServer(address string, tlsConfig *tls.Config) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", handleHealth)
+	mux.HandleFunc("/databases", handleDatabases)
+	mux.HandleFunc("/databases/close", handleCloseDatabase)
+	mux.HandleFunc("/databases/rescan", handleRescan)
+	mux.HandleFunc("/databases/rename", handleRenameDatabase)
+	mux.HandleFunc("/metadata", handleMetadata)
+	mux.HandleFunc("/query", handleQuery)
+	mux.HandleFunc("/stats", handleStats)
+
+	httpServer := &http.Server{Addr: address, Handler: requireClientHeader(mux), TLSConfig: tlsConfig}
+	if tlsConfig != nil {
+		return httpServer.ListenAndServeTLS("", "")
+	}
+	return httpServer.ListenAndServe()
+}
+
+//requireClientHeader rejects requests other than GET and HEAD that don't carry clientHeader
+func requireClientHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Header.Get(clientHeader) == "" {
+			writeHTTPError(w, errMissingClientHeader)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+//writeHTTPError sends err as an engineError with a status code matching its class
+func writeHTTPError(w http.ResponseWriter, err error) {
+	engineErr := toEngineError(err)
+	status := http.StatusInternalServerError
+	switch {
+	case engineErr == errMethodNotAllowed:
+		status = http.StatusMethodNotAllowed
+	case engineErr == errMissingClientHeader:
+		status = http.StatusForbidden
+	case engineErr.Class == classInvalidState:
+		status = http.StatusForbidden
+	case engineErr.Class == classObjectState:
+		status = http.StatusConflict
+	case engineErr.Class == classInvalidCatalog:
+		status = http.StatusNotFound
+	case engineErr.Class == classSyntaxOrAccess:
+		status = http.StatusBadRequest
+	case engineErr.Class == classOperatorAborted:
+		status = http.StatusGatewayTimeout
+	}
+	writeJSON(w, status, engineErr)
+}
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, notificationResponse{Message: "Alive"})
+}
+
+//handleDatabases lists databases on GET, and creates or drops the database given by the name parameter on POST and DELETE.
+//POST accepts optional blocksize and tablespace parameters, DELETE a force parameter to drop a database paired with sessions.
+func handleDatabases(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, databasesResponse{Databases: dbmanager.listDatabaseNames()})
+	case http.MethodPost:
+		if name == "" {
+			writeHTTPError(w, errMissingDatabaseName)
+			return
+		}
+		root, err := settings.tablespaceRoot(r.URL.Query().Get("tablespace"))
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		blockSize := settings.BlockSize
+		if value := r.URL.Query().Get("blocksize"); value != "" {
+			parsed, err := parseBlockSize(value)
+			if err != nil {
+				writeHTTPError(w, err)
+				return
+			}
+			blockSize = parsed
+		}
+		err = dbmanager.createDatabase(name, root, blockSize)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, notificationResponse{Message: "Database " + name + " created."})
+	case http.MethodDelete:
+		if name == "" {
+			writeHTTPError(w, errMissingDatabaseName)
+			return
+		}
+		_, err := dbmanager.deleteDatabase(name, r.URL.Query().Get("force") == "true", noSession)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, notificationResponse{Message: "Database " + name + " deleted."})
+	default:
+		writeHTTPError(w, errMethodNotAllowed)
+	}
+}
+
+//handleCloseDatabase unloads the database given by the name parameter
+func handleCloseDatabase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeHTTPError(w, errMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if err := dbmanager.closeDatabase(name); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, notificationResponse{Message: "Database " + name + " closed."})
+}
+
+//handleRenameDatabase renames the database given by the name parameter to the to parameter
+func handleRenameDatabase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeHTTPError(w, errMethodNotAllowed)
+		return
+	}
+	name, newName := r.URL.Query().Get("name"), r.URL.Query().Get("to")
+	if name == "" || newName == "" {
+		writeHTTPError(w, newEngineError("MissingParameter", classSyntaxOrAccess, "The name and to parameters are required"))
+		return
+	}
+	if err := dbmanager.renameDatabase(name, newName); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, notificationResponse{Message: "Database " + name + " renamed to " + newName + "."})
+}
+
+//handleRescan registers database files added to or removed from every tablespace since startup
+func handleRescan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeHTTPError(w, errMethodNotAllowed)
+		return
+	}
+	for _, root := range settings.roots() {
+		if err := dbmanager.rescan(root); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, databasesResponse{Databases: dbmanager.listDatabaseNames()})
+}
+
+func handleMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, errMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, metadataResponse{Databases: dbmanager.getMetadata()})
+}
+
+type statsResponse struct {
+	QueryCache resultCacheStats `json:"QueryCache"`
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, errMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, statsResponse{QueryCache: queryCache.stats()})
+}
+
+//handleQuery runs the SQL in the request body against the database given by the database parameter
+func handleQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeHTTPError(w, errMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("database")
+	db, err := dbmanager.acquire(name)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	defer dbmanager.release(name)
+	query, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxQueryBytes))
+	if err != nil {
+		writeHTTPError(w, newEngineError("InvalidRequest", classSyntaxOrAccess, err.Error()))
+		return
+	}
+
+	results, err := executeQuery(db, string(query))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, queryResponse{Results: results})
+}
@@ -2,19 +2,27 @@ package main
 
 import (
 	"bytes"
+	"container/list"
+	"crypto/tls"
 	"encoding/json"
-	"errors"
+	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/modest-sql/common"
 
 	"github.com/modest-sql/data"
+	"github.com/modest-sql/engine/executor"
 	"github.com/modest-sql/network"
 	"github.com/modest-sql/parser"
 	"github.com/modest-sql/transaction"
@@ -23,7 +31,22 @@ import (
 //DBManager implements simple CRUD functions to manage databases
 type DBManager struct {
 	databases sync.Map
+	files     sync.Map
 	paired    sync.Map
+	readOnly  bool
+	lazyLoad  bool
+	maxOpen   int
+
+	//readOnlyDatabases holds the databases that only accept SELECT, readOnlySessions the sessions paired read-only
+	readOnlyDatabases map[string]bool
+	readOnlySessions  sync.Map
+
+	//mutex guards loading and closing databases, the LRU order of loaded databases, inUse and creating
+	mutex       sync.Mutex
+	lru         *list.List
+	lruElements map[string]*list.Element
+	inUse       map[string]int
+	creating    map[string]bool
 }
 
 type databaseMeta struct {
@@ -31,48 +54,330 @@ type databaseMeta struct {
 	Tables       []*data.Table `json:"Tables"`
 }
 
+type metadataResponse struct {
+	Databases []databaseMeta `json:"Databases"`
+}
+
+type transactionsResponse struct {
+	Transactions interface{} `json:"Transactions"`
+}
+
+type queryResult struct {
+	Rows            interface{} `json:"Rows"`
+	RowCount        int         `json:"RowCount"`
+	ExecutionTimeMs int64       `json:"ExecutionTimeMs"`
+}
+
+//newQueryResult wraps the rows returned by a SELECT with the row count and the time it took
+func newQueryResult(rows interface{}, elapsed time.Duration) queryResult {
+	rowCount := 0
+	switch value := reflect.ValueOf(rows); value.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice:
+		rowCount = value.Len()
+	}
+	return queryResult{Rows: rows, RowCount: rowCount, ExecutionTimeMs: int64(elapsed / time.Millisecond)}
+}
+
+//getMetadata lists every known database, with the tables of those currently loaded
 func (DBM *DBManager) getMetadata() (databaseMetaArray []databaseMeta) {
-	DBM.databases.Range(func(ki, vi interface{}) bool {
-		k, v := ki.(string), vi.(*data.Database)
-		databaseMetaArray = append(databaseMetaArray, databaseMeta{DatabaseName: k, Tables: v.AllTables()})
-		return true
-	})
+	for _, name := range DBM.listDatabaseNames() {
+		meta := databaseMeta{DatabaseName: name}
+		if vi, ok := DBM.databases.Load(name); ok {
+			meta.Tables = vi.(*data.Database).AllTables()
+		}
+		databaseMetaArray = append(databaseMetaArray, meta)
+	}
 	return
 }
 
-//LoadAllDatabases loads all the existing databses files into memory
+//LoadAllDatabases registers all the existing databses files in path, loading them into memory unless lazy loading is enabled.
+//Names are unique across roots, so a file named like a database registered from another root is skipped.
 func (DBM *DBManager) loadAllDatabases(path string) (err error) {
 	databasesFiles, err := listDatabases(path)
 	if err != nil {
 		return err
 	}
 	for _, databaseFile := range databasesFiles {
-		db, err := data.LoadDatabase(filepath.Join(path, databaseFile.Name()))
+		if registered, known := DBM.files.Load(databaseFile.Name()); known {
+			log.Println("Skipping database file", filepath.Join(path, databaseFile.Name()), "already registered from", registered)
+			continue
+		}
+		filePath, err := databasePath(path, databaseFile.Name())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Skipping database file", filepath.Join(path, databaseFile.Name()), err)
+			continue
+		}
+		DBM.files.Store(databaseFile.Name(), filePath)
+		if DBM.lazyLoad {
+			continue
+		}
+		db, err := data.LoadDatabase(filePath)
 		if err != nil {
 			return err
 		}
 		DBM.databases.Store(databaseFile.Name(), db)
+		DBM.touch(databaseFile.Name())
 	}
 	return nil
 }
 
-//CreateDatabase creates a new databse and pairs it to the session
-func (DBM *DBManager) createDatabase(sessionID int64, name string, path string, blocksize int64) (err error) {
-	db, err := data.NewDatabase(filepath.Join(path, name), blocksize)
+//rescan registers database files added to path since startup and unregisters those removed from it
+func (DBM *DBManager) rescan(path string) error {
+	databasesFiles, err := listDatabases(path)
 	if err != nil {
 		return err
 	}
+
+	DBM.mutex.Lock()
+	defer DBM.mutex.Unlock()
+
+	onDisk := make(map[string]bool)
+	for _, databaseFile := range databasesFiles {
+		name := databaseFile.Name()
+		onDisk[name] = true
+		if _, known := DBM.files.Load(name); known || DBM.creating[name] {
+			continue
+		}
+		filePath, err := databasePath(path, name)
+		if err != nil {
+			log.Println("Skipping database file", name, err)
+			continue
+		}
+		if !DBM.lazyLoad {
+			db, err := data.LoadDatabase(filePath)
+			if err != nil {
+				log.Println("Error loading new database", name, err)
+				continue
+			}
+			DBM.databases.Store(name, db)
+			DBM.touchLocked(name)
+		}
+		log.Println("Registered new database", name)
+		DBM.files.Store(name, filePath)
+	}
+
+	DBM.files.Range(func(ki, vi interface{}) bool {
+		name, filePath := ki.(string), vi.(string)
+		if onDisk[name] || filepath.Dir(filePath) != filepath.Clean(path) {
+			return true
+		}
+		if dbpointer, loaded := DBM.databases.Load(name); loaded {
+			if DBM.inUseLocked(name, dbpointer.(*data.Database)) {
+				log.Println("Database file", name, "was removed while in use, keeping it loaded")
+				return true
+			}
+			DBM.closeLocked(name, dbpointer.(*data.Database))
+		}
+		log.Println("Unregistered removed database", name)
+		DBM.files.Delete(name)
+		return true
+	})
+	return nil
+}
+
+//touch marks a database as the most recently used
+func (DBM *DBManager) touch(name string) {
+	DBM.mutex.Lock()
+	defer DBM.mutex.Unlock()
+	DBM.touchLocked(name)
+}
+
+//initLocked allocates the manager's bookkeeping on first use
+func (DBM *DBManager) initLocked() {
+	if DBM.lru == nil {
+		DBM.lru = list.New()
+		DBM.lruElements = make(map[string]*list.Element)
+		DBM.inUse = make(map[string]int)
+		DBM.creating = make(map[string]bool)
+	}
+}
+
+func (DBM *DBManager) touchLocked(name string) {
+	DBM.initLocked()
+	if element, ok := DBM.lruElements[name]; ok {
+		DBM.lru.MoveToFront(element)
+		return
+	}
+	DBM.lruElements[name] = DBM.lru.PushFront(name)
+}
+
+//loadLocked returns a loaded database, loading it from its registered file if it isn't loaded
+func (DBM *DBManager) loadLocked(name string) (*data.Database, error) {
+	if dbpointer, ok := DBM.databases.Load(name); ok {
+		DBM.touchLocked(name)
+		return dbpointer.(*data.Database), nil
+	}
+	//Lazily loaded databases are loaded here, as are closed or evicted ones whether or not lazy loading is enabled
+	path, ok := DBM.files.Load(name)
+	if !ok {
+		return nil, errDatabaseNotFound
+	}
+
+	log.Println("Loading database", name)
+	db, err := data.LoadDatabase(path.(string))
+	if err != nil {
+		return nil, err
+	}
 	DBM.databases.Store(name, db)
-	return DBM.pair(sessionID, name)
+	DBM.touchLocked(name)
+	DBM.evictLocked()
+	return db, nil
+}
+
+//sessionsPairedWith returns the sessions paired with db
+func (DBM *DBManager) sessionsPairedWith(db *data.Database) []int64 {
+	sessions := make([]int64, 0)
+	DBM.paired.Range(func(ki, vi interface{}) bool {
+		k, v := ki.(int64), vi.(*data.Database)
+		if v == db {
+			sessions = append(sessions, k)
+		}
+		return true
+	})
+	return sessions
+}
+
+//inUseLocked reports whether a loaded database is paired with a session or acquired by a running request
+func (DBM *DBManager) inUseLocked(name string, db *data.Database) bool {
+	return DBM.inUse[name] > 0 || len(DBM.sessionsPairedWith(db)) > 0
+}
+
+//evictLocked closes the least recently used databases that aren't in use until at most maxOpen remain loaded
+func (DBM *DBManager) evictLocked() {
+	if DBM.maxOpen <= 0 {
+		return
+	}
+	element := DBM.lru.Back()
+	for DBM.lru.Len() > DBM.maxOpen && element != nil {
+		name := element.Value.(string)
+		element = element.Prev()
+		dbpointer, ok := DBM.databases.Load(name)
+		if !ok || DBM.inUseLocked(name, dbpointer.(*data.Database)) {
+			continue
+		}
+		log.Println("Evicting database", name)
+		DBM.closeLocked(name, dbpointer.(*data.Database))
+	}
 }
 
-//Pair pairs a session with a loaded database
-func (DBM *DBManager) pair(sessionID int64, name string) (err error) {
-	databasePointer, ok := DBM.databases.Load(name)
+//closeLocked unloads a database. data.Database has no close or flush method, so this doesn't flush anything:
+//it drops the engine's references and relies on the data layer having written every completed command to the file.
+func (DBM *DBManager) closeLocked(name string, db *data.Database) {
+	DBM.databases.Delete(name)
+	if element, ok := DBM.lruElements[name]; ok {
+		DBM.lru.Remove(element)
+		delete(DBM.lruElements, name)
+	}
+	queryCache.forget(db)
+}
+
+//closeDatabase unloads a database that no session is using. Its file stays registered and is loaded again on next use.
+func (DBM *DBManager) closeDatabase(name string) error {
+	DBM.mutex.Lock()
+	defer DBM.mutex.Unlock()
+	dbpointer, ok := DBM.databases.Load(name)
 	if !ok {
-		return errors.New("Error pairing, Database isn't loaded or doesnt exist")
+		return errDatabaseNotFound
+	}
+	if DBM.inUseLocked(name, dbpointer.(*data.Database)) {
+		return errDatabaseInUse
+	}
+	DBM.closeLocked(name, dbpointer.(*data.Database))
+	return nil
+}
+
+//acquire gets a database for a request that isn't tied to a paired session, keeping it loaded until release is called
+func (DBM *DBManager) acquire(name string) (*data.Database, error) {
+	DBM.mutex.Lock()
+	defer DBM.mutex.Unlock()
+	db, err := DBM.loadLocked(name)
+	if err != nil {
+		return nil, err
+	}
+	DBM.inUse[name]++
+	return db, nil
+}
+
+func (DBM *DBManager) release(name string) {
+	DBM.mutex.Lock()
+	defer DBM.mutex.Unlock()
+	DBM.inUse[name]--
+	if DBM.inUse[name] <= 0 {
+		delete(DBM.inUse, name)
+	}
+}
+
+//CreateDatabase creates a new databse and registers it with the manager
+func (DBM *DBManager) createDatabase(name string, path string, blocksize int64) (err error) {
+	if DBM.readOnly {
+		return errReadOnly
+	}
+	if err := validateDatabaseName(name); err != nil {
+		return err
+	}
+	filePath, err := databasePath(path, name)
+	if err != nil {
+		return err
+	}
+
+	//Reserve the name so concurrent creations and rescans leave the file alone while it's being written
+	DBM.mutex.Lock()
+	DBM.initLocked()
+	_, known := DBM.files.Load(name)
+	if known || DBM.creating[name] {
+		DBM.mutex.Unlock()
+		return errDatabaseExists
+	}
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		DBM.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+		return errDatabaseExists
+	}
+	DBM.creating[name] = true
+	DBM.mutex.Unlock()
+
+	db, err := data.NewDatabase(filePath, blocksize)
+
+	DBM.mutex.Lock()
+	defer DBM.mutex.Unlock()
+	delete(DBM.creating, name)
+	if err != nil {
+		return err
+	}
+	DBM.files.Store(name, filePath)
+	DBM.databases.Store(name, db)
+	DBM.touchLocked(name)
+	DBM.evictLocked()
+	return nil
+}
+
+//listDatabaseNames returns the names of all known databases in alphabetical order
+func (DBM *DBManager) listDatabaseNames() []string {
+	names := make([]string, 0)
+	DBM.files.Range(func(ki, vi interface{}) bool {
+		names = append(names, ki.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+//Pair pairs a session with a database, loading it if needed. A session paired read-only can only run SELECT.
+func (DBM *DBManager) pair(sessionID int64, name string, readOnly bool) (err error) {
+	DBM.mutex.Lock()
+	defer DBM.mutex.Unlock()
+	databasePointer, err := DBM.loadLocked(name)
+	if err != nil {
+		return err
 	}
 	DBM.paired.Store(sessionID, databasePointer)
+	if readOnly {
+		DBM.readOnlySessions.Store(sessionID, true)
+	} else {
+		DBM.readOnlySessions.Delete(sessionID)
+	}
 	return nil
 }
 
@@ -81,40 +386,160 @@ func (DBM *DBManager) unpair(sessionID int64) (err error) {
 	_, ok := DBM.paired.Load(sessionID)
 	if ok {
 		DBM.paired.Delete(sessionID)
+		DBM.readOnlySessions.Delete(sessionID)
 		return nil
 	}
-	return errors.New("Database specified wasn't found")
+	return errSessionNotPaired
 }
 
-//deleteDatabase deletes the database file ans unpairs all sessions
-func (DBM *DBManager) deleteDatabase(name string, path string) error {
+//noSession identifies requests that don't come from a network session
+const noSession int64 = -1
 
-	dbpointer, ok := DBM.databases.Load(name)
-	if ok {
-		sessionToUnpair := make([]int64, 0)
-		DBM.paired.Range(func(ki, vi interface{}) bool {
-			k, v := ki.(int64), vi.(*data.Database)
-			if v == dbpointer {
-				sessionToUnpair = append(sessionToUnpair, k)
-			}
-			return true
-		})
-		for sessionID := range sessionToUnpair {
-			err := DBM.unpair(int64(sessionID))
-			if err != nil {
-				return err
+//deleteDatabase unloads a database and deletes its file. A database paired with sessions other than requester
+//is only deleted when force is set, and a requester paired read-only can't delete any database. Every session that
+//was paired with it is unpaired and returned.
+func (DBM *DBManager) deleteDatabase(name string, force bool, requester int64) ([]int64, error) {
+	if DBM.readOnly {
+		return nil, errReadOnly
+	}
+	if DBM.readOnlyDatabases[name] {
+		return nil, errDatabaseReadOnly
+	}
+	if _, ok := DBM.readOnlySessions.Load(requester); ok {
+		return nil, errSessionReadOnly
+	}
+
+	DBM.mutex.Lock()
+	defer DBM.mutex.Unlock()
+
+	registered, ok := DBM.files.Load(name)
+	if !ok {
+		return nil, errDatabaseNotFound
+	}
+	//The file may have been swapped for a symlink since it was registered
+	path, err := databasePath(filepath.Dir(registered.(string)), name)
+	if err != nil {
+		return nil, err
+	}
+	dbpointer, loaded := DBM.databases.Load(name)
+	sessionsToUnpair := make([]int64, 0)
+	if loaded {
+		sessionsToUnpair = DBM.sessionsPairedWith(dbpointer.(*data.Database))
+		otherSessions := 0
+		for _, sessionID := range sessionsToUnpair {
+			if sessionID != requester {
+				otherSessions++
 			}
 		}
-		DBM.databases.Delete(name)
-	} else {
-		return errors.New("Pointer to database not found")
+		if DBM.inUse[name] > 0 || (otherSessions > 0 && !force) {
+			return nil, errDatabaseInUse
+		}
 	}
 
-	err := deleteDatabaseFile(name, path)
+	//Moving the file aside first leaves everything untouched if it can't be deleted
+	tombstone, err := tombstoneDatabaseFile(path)
 	if err != nil {
+		return nil, err
+	}
+	for _, sessionID := range sessionsToUnpair {
+		DBM.paired.Delete(sessionID)
+		DBM.readOnlySessions.Delete(sessionID)
+	}
+	if loaded {
+		DBM.closeLocked(name, dbpointer.(*data.Database))
+	}
+	DBM.files.Delete(name)
+
+	//The database is already gone at this point, a leftover tombstone is skipped by listDatabases
+	if err := os.Remove(tombstone); err != nil {
+		log.Println("Error removing database file", tombstone, err)
+	}
+	return sessionsToUnpair, nil
+}
+
+//renameDatabase renames a database and its file. A database paired with sessions or acquired by a request can't be
+//renamed, since commands they queued against the loaded database could still write to the file after it's reloaded.
+//A loaded database is unloaded and loaded again under its new name on next use.
+func (DBM *DBManager) renameDatabase(name string, newName string) error {
+	if DBM.readOnly {
+		return errReadOnly
+	}
+	if DBM.readOnlyDatabases[name] {
+		return errDatabaseReadOnly
+	}
+	if err := validateDatabaseName(newName); err != nil {
+		return err
+	}
+
+	DBM.mutex.Lock()
+	defer DBM.mutex.Unlock()
+
+	path, ok := DBM.files.Load(name)
+	if !ok {
+		return errDatabaseNotFound
+	}
+	newPath, err := databasePath(filepath.Dir(path.(string)), newName)
+	if err != nil {
+		return err
+	}
+	if _, exists := DBM.files.Load(newName); exists || DBM.creating[newName] {
+		return errDatabaseExists
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return errDatabaseExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	dbpointer, loaded := DBM.databases.Load(name)
+	if loaded && DBM.inUseLocked(name, dbpointer.(*data.Database)) {
+		return errDatabaseInUse
+	}
+
+	if err := os.Rename(path.(string), newPath); err != nil {
 		return err
 	}
+	DBM.files.Delete(name)
+	DBM.files.Store(newName, newPath)
+	if loaded {
+		DBM.closeLocked(name, dbpointer.(*data.Database))
+	}
+	return nil
+}
+
+//checkWritable rejects any command other than SELECT when the manager, db or the session running the commands is read-only.
+//Requests that don't come from a paired session pass noSession.
+func (DBM *DBManager) checkWritable(sessionID int64, db *data.Database, commands []common.Command) error {
+	err := DBM.writeError(sessionID, db)
+	if err == nil {
+		return nil
+	}
+	for _, command := range commands {
+		if _, isSelect := command.(*common.SelectTableCommand); !isSelect {
+			return err
+		}
+	}
+	return nil
+}
 
+//writeError returns the error a command modifying db should fail with, nil when it is allowed
+func (DBM *DBManager) writeError(sessionID int64, db *data.Database) error {
+	if DBM.readOnly {
+		return errReadOnly
+	}
+	if _, ok := DBM.readOnlySessions.Load(sessionID); ok {
+		return errSessionReadOnly
+	}
+	readOnly := false
+	DBM.databases.Range(func(ki, vi interface{}) bool {
+		if vi.(*data.Database) == db {
+			readOnly = DBM.readOnlyDatabases[ki.(string)]
+			return false
+		}
+		return true
+	})
+	if readOnly {
+		return errDatabaseReadOnly
+	}
 	return nil
 }
 
@@ -124,19 +549,222 @@ func (DBM *DBManager) getPair(sessionID int64) (*data.Database, error) {
 	if ok {
 		return dbpointer.(*data.Database), nil
 	}
-	return nil, errors.New("No active database selected")
+	return nil, errNoActiveDatabase
 }
 
+//findTable looks up a table by name in the given database
+func findTable(db *data.Database, name string) (*data.Table, bool) {
+	for _, table := range db.AllTables() {
+		if table.TableName == name {
+			return table, true
+		}
+	}
+	return nil, false
+}
+
+//parseTableDefinition decodes a NewTable payload, which must be a single CREATE TABLE statement
+func parseTableDefinition(definition string) (*common.CreateTableCommand, error) {
+	commands, err := parser.Parse(bytes.NewReader([]byte(definition)))
+	if err != nil {
+		return nil, wrapError("SyntaxError", classSyntaxOrAccess, err)
+	}
+	if len(commands) != 1 {
+		return nil, newEngineError("SyntaxError", classSyntaxOrAccess, "Table definition must contain exactly one statement")
+	}
+	createTableCommand, ok := commands[0].(*common.CreateTableCommand)
+	if !ok {
+		return nil, newEngineError("SyntaxError", classSyntaxOrAccess, "Table definition must be a CREATE TABLE statement")
+	}
+	return createTableCommand, nil
+}
+
+//statementResult is the outcome of a single statement run by executeQuery
+type statementResult struct {
+	Result *queryResult `json:"Result,omitempty"`
+	Error  *engineError `json:"Error,omitempty"`
+}
+
+//commandExecutor runs the commands of every front end, keeping the query cache in step with the databases
+var commandExecutor = executor.Executor{
+	Timeout:   time.Duration(settings.QueryTimeoutMs) * time.Millisecond,
+	Completed: invalidateOnWrite,
+}
+
+//newStatementResult converts the executor's outcome of command into the result sent to clients
+func newStatementResult(command common.Command, result executor.Result) (statement statementResult) {
+	if result.Err != nil {
+		statement.Error = wrapError("CommandFailed", classInternal, result.Err)
+		return
+	}
+	if executor.IsSelect(command) {
+		selectResult := newQueryResult(result.Rows, result.Elapsed)
+		statement.Result = &selectResult
+	}
+	return
+}
+
+//executeQuery parses and runs query against db, blocking until every statement has completed
+func executeQuery(db *data.Database, query string) ([]statementResult, error) {
+	if cachedRows, ok := queryCache.get(db, query); ok {
+		results := make([]statementResult, len(cachedRows))
+		for i, rows := range cachedRows {
+			selectResult := newQueryResult(rows, 0)
+			results[i].Result = &selectResult
+		}
+		return results, nil
+	}
+
+	commands, err := parser.Parse(bytes.NewReader([]byte(query)))
+	if err != nil {
+		return nil, wrapError("SyntaxError", classSyntaxOrAccess, err)
+	}
+	if err := dbmanager.checkWritable(noSession, db, commands); err != nil {
+		return nil, err
+	}
+
+	fill := queryCache.fill(db, query, commands)
+	outcomes := commandExecutor.Run(db, commands, func(index int, result executor.Result) {
+		fill.record(index, result.Rows, result.Err)
+	})
+	results := make([]statementResult, len(commands))
+	for i, command := range commands {
+		results[i] = newStatementResult(command, outcomes[i])
+	}
+	return results, nil
+}
+
+//executeCommand runs a single parsed command against db and waits for its result
+func executeCommand(db *data.Database, command common.Command) statementResult {
+	return newStatementResult(command, commandExecutor.Run(db, []common.Command{command}, nil)[0])
+}
+
+//validateBlockSize checks that blockSize is a power of two within the supported range
+func validateBlockSize(blockSize int64) error {
+	if blockSize < minBlockSize || blockSize > maxBlockSize || blockSize&(blockSize-1) != 0 {
+		return newEngineError("InvalidBlockSize", classSyntaxOrAccess, fmt.Sprintf("Block size must be a power of two between %d and %d", minBlockSize, maxBlockSize))
+	}
+	return nil
+}
+
+//parseBlockSize parses and validates a client-supplied block size
+func parseBlockSize(value string) (int64, error) {
+	blockSize, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, newEngineError("InvalidBlockSize", classSyntaxOrAccess, "Block size must be an integer")
+	}
+	return blockSize, validateBlockSize(blockSize)
+}
+
+//parseNewDatabasePayload splits a NewDatabase payload of the form name[:blocksize[:tablespace]],
+//using defaultBlockSize when the size is missing or empty
+func parseNewDatabasePayload(payload string, defaultBlockSize int64) (string, int64, string, error) {
+	fields := strings.SplitN(payload, ":", 3)
+	blockSize := defaultBlockSize
+	if len(fields) > 1 && fields[1] != "" {
+		parsed, err := parseBlockSize(fields[1])
+		if err != nil {
+			return "", 0, "", err
+		}
+		blockSize = parsed
+	}
+	tablespace := ""
+	if len(fields) > 2 {
+		tablespace = fields[2]
+	}
+	return fields[0], blockSize, tablespace, nil
+}
+
+//droppedSuffix marks a database file that is being deleted
+const droppedSuffix = ".dropped"
+
+//listDatabases lists the database files in path, skipping directories and files left by an interrupted drop
 func listDatabases(path string) ([]os.FileInfo, error) {
 	files, err := ioutil.ReadDir(path)
-	return files, err
+	if err != nil {
+		return nil, err
+	}
+	databasesFiles := make([]os.FileInfo, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || strings.HasSuffix(file.Name(), droppedSuffix) {
+			continue
+		}
+		databasesFiles = append(databasesFiles, file)
+	}
+	return databasesFiles, nil
+}
+
+//tombstoneDatabaseFile renames a database file so it is no longer listed, returning the new path
+func tombstoneDatabaseFile(path string) (string, error) {
+	tombstone := path + droppedSuffix
+	return tombstone, os.Rename(path, tombstone)
+}
+
+//maxDatabaseNameLength keeps database file names well within the limits of every filesystem
+const maxDatabaseNameLength = 64
+
+//validateDatabaseName accepts names of letters, digits, '_', '-' and '.' that don't start with '.',
+//so a client-supplied name can't contain a path separator, be "..", or look like a tombstone.
+//It's only applied to the names of new databases: files already in a root keep loading under their names.
+func validateDatabaseName(name string) error {
+	if name == "" || len(name) > maxDatabaseNameLength || name[0] == '.' || strings.HasSuffix(name, droppedSuffix) {
+		return errInvalidDatabaseName
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return errInvalidDatabaseName
+		}
+	}
+	return nil
+}
+
+//databasePath returns the file of database name in root. It fails unless the canonical path lies directly in root
+//and is either missing or a regular file, so symlinks placed in root can't redirect reads or deletes elsewhere.
+func databasePath(root string, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/') ||
+		strings.ContainsRune(name, filepath.Separator) || strings.HasSuffix(name, droppedSuffix) {
+		return "", errInvalidDatabaseName
+	}
+	path := filepath.Join(root, name)
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if filepath.Dir(absPath) != absRoot {
+		return "", errInvalidDatabaseName
+	}
+	if info, err := os.Lstat(path); err == nil && !info.Mode().IsRegular() {
+		return "", errInvalidDatabaseName
+	} else if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return path, nil
 }
 
-func deleteDatabaseFile(name string, path string) error {
-	err := os.Remove(filepath.Join(path, name))
-	return err
+//parseLoadPayload splits a LoadDatabase payload of the form name[:readonly]
+func parseLoadPayload(payload string) (string, bool) {
+	if strings.HasSuffix(payload, ":readonly") {
+		return strings.TrimSuffix(payload, ":readonly"), true
+	}
+	return payload, false
 }
 
+//parseDropPayload splits a DropDb payload of the form name[:force]
+func parseDropPayload(payload string) (string, bool) {
+	if strings.HasSuffix(payload, ":force") {
+		return strings.TrimSuffix(payload, ":force"), true
+	}
+	return payload, false
+}
+
+const (
+	minBlockSize = 512
+	maxBlockSize = 65536
+)
+
 type config struct {
 	Host          string
 	Port          string
@@ -144,10 +772,71 @@ type config struct {
 	MaxSessions   int
 	BlockSize     int64
 	EnableLogging bool
+
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	RequireTLS      bool
+
+	QueryTimeoutMs int64
+
+	//SessionIdleTimeout is how many seconds a session may stay idle before its database is unpaired, 0 disables it
+	SessionIdleTimeout int64
+
+	HTTPPort      string
+	WebSocketPort string
+
+	//WebSocketOrigins lists the origins, such as https://console.example.com, whose pages may open WebSocket sessions
+	WebSocketOrigins []string
+
+	ReadOnly bool
+
+	//QueryCacheEntries caps the cached SELECT queries, evicting the least recently used one. 0 disables the cache.
+	QueryCacheEntries int
+
+	LazyLoad         bool
+	MaxOpenDatabases int
+	WatchRoot        bool
+
+	//ReadOnlyDatabases lists databases that only accept SELECT, such as archived datasets
+	ReadOnlyDatabases []string
+
+	//Tablespaces maps names to additional storage roots, Root is the default tablespace
+	Tablespaces map[string]string
+}
+
+//defaultTablespace names the tablespace stored in Root
+const defaultTablespace = "default"
+
+//tablespaceRoot returns the directory of a tablespace, Root when name is empty or default
+func (c config) tablespaceRoot(name string) (string, error) {
+	if name == "" || name == defaultTablespace {
+		return c.Root, nil
+	}
+	root, ok := c.Tablespaces[name]
+	if !ok {
+		return "", errTablespaceNotFound
+	}
+	return root, nil
+}
+
+//roots returns the directories of every tablespace, starting with Root
+func (c config) roots() []string {
+	names := make([]string, 0, len(c.Tablespaces))
+	for name := range c.Tablespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	roots := []string{c.Root}
+	for _, name := range names {
+		roots = append(roots, c.Tablespaces[name])
+	}
+	return roots
 }
 
 var dbmanager DBManager
 var settings = loadConfig("settings.json")
+var queryCache = newResultCache(settings.QueryCacheEntries)
 
 func loadConfig(path string) (c config) {
 	raw, err := ioutil.ReadFile(path)
@@ -160,52 +849,127 @@ func loadConfig(path string) (c config) {
 }
 
 func handleRequest(server *network.Server, request network.Request) {
+	touchSession(request.SessionID)
+
 	switch request.Response.Type {
 	case network.KeepAlive:
 		server.Send(request.SessionID, network.Response{Type: network.KeepAlive, Data: "Alive"})
 	case network.NewDatabase:
-		err := dbmanager.createDatabase(request.SessionID, request.Response.Data, settings.Root, settings.BlockSize)
+		name, blockSize, tablespace, err := parseNewDatabasePayload(request.Response.Data, settings.BlockSize)
+		if err != nil {
+			sendError(server, request.SessionID, err)
+			return
+		}
+		root, err := settings.tablespaceRoot(tablespace)
 		if err != nil {
-			server.Send(request.SessionID, network.Response{Type: network.Error, Data: err.Error()})
+			sendError(server, request.SessionID, err)
+			return
+		}
+		err = dbmanager.createDatabase(name, root, blockSize)
+		if err != nil {
+			sendError(server, request.SessionID, err)
+			return
+		}
+		err = dbmanager.pair(request.SessionID, name, false)
+		if err != nil {
+			sendError(server, request.SessionID, err)
 			return
 		}
 	case network.LoadDatabase:
-		err := dbmanager.pair(request.SessionID, request.Response.Data)
+		name, readOnly := parseLoadPayload(request.Response.Data)
+		err := dbmanager.pair(request.SessionID, name, readOnly)
 		if err != nil {
-			server.Send(request.SessionID, network.Response{Type: network.Error, Data: err.Error()})
+			sendError(server, request.SessionID, err)
 			return
 		}
 	case network.NewTable:
+		databaseTemp, err := dbmanager.getPair(request.SessionID)
+		if err != nil {
+			sendError(server, request.SessionID, err)
+			return
+		}
+		createTableCommand, err := parseTableDefinition(request.Response.Data)
+		if err != nil {
+			sendError(server, request.SessionID, err)
+			return
+		}
+		if err := dbmanager.checkWritable(request.SessionID, databaseTemp, []common.Command{createTableCommand}); err != nil {
+			sendError(server, request.SessionID, err)
+			return
+		}
+		if _, exists := findTable(databaseTemp, createTableCommand.TableName); exists {
+			sendError(server, request.SessionID, newEngineError("DuplicateTable", classSyntaxOrAccess, "Table "+createTableCommand.TableName+" already exists"))
+			return
+		}
+		function := func(result interface{}, err error) {
+			if err != nil {
+				sendError(server, request.SessionID, wrapError("CommandFailed", classInternal, err))
+				return
+			}
+			server.Send(request.SessionID, network.Response{Type: network.NewTable, Data: "Table " + createTableCommand.TableName + " created"})
+		}
+		transaction.AddCommands([]common.Command{commandExecutor.Prepare(databaseTemp, createTableCommand, function)})
 	case network.FindTable:
+		databaseTemp, err := dbmanager.getPair(request.SessionID)
+		if err != nil {
+			sendError(server, request.SessionID, err)
+			return
+		}
+		table, ok := findTable(databaseTemp, request.Response.Data)
+		if !ok {
+			sendError(server, request.SessionID, newEngineError("UndefinedTable", classSyntaxOrAccess, "Table "+request.Response.Data+" not found"))
+			return
+		}
+		tableJSON, err := json.Marshal(table)
+		if err != nil {
+			log.Println("Error encoding table:", err)
+			sendError(server, request.SessionID, err)
+			return
+		}
+		server.Send(request.SessionID, network.Response{Type: network.FindTable, Data: string(tableJSON)})
 	case network.GetMetadata:
-		databaseMetaArray := dbmanager.getMetadata()
-		databaseMetaArrayJSON, err := json.Marshal(databaseMetaArray)
+		metadataJSON, err := json.Marshal(metadataResponse{Databases: dbmanager.getMetadata()})
 		if err != nil {
 			log.Println("Error encoding metadata:", err)
+			sendError(server, request.SessionID, err)
+			return
 		}
-		server.Send(request.SessionID, network.Response{Type: network.GetMetadata, Data: "{Databases:" + string(databaseMetaArrayJSON) + "}"})
+		server.Send(request.SessionID, network.Response{Type: network.GetMetadata, Data: string(metadataJSON)})
 	case network.Query:
 		databaseTemp, err := dbmanager.getPair(request.SessionID)
 		if err != nil {
-			server.Send(request.SessionID, network.Response{Type: network.Error, Data: err.Error()})
+			sendError(server, request.SessionID, err)
+			return
+		}
+		if cachedRows, ok := queryCache.get(databaseTemp, request.Response.Data); ok {
+			for _, rows := range cachedRows {
+				resultJSON, _ := json.Marshal(newQueryResult(rows, 0))
+				server.Send(request.SessionID, network.Response{Type: network.Query, Data: string(resultJSON)})
+			}
 			return
 		}
+
 		reader := bytes.NewReader([]byte(request.Response.Data))
 		commands, err := parser.Parse(reader)
 		if err != nil {
-			server.Send(request.SessionID, network.Response{Type: network.Error, Data: err.Error()})
+			sendError(server, request.SessionID, wrapError("SyntaxError", classSyntaxOrAccess, err))
+			return
+		}
+		if err := dbmanager.checkWritable(request.SessionID, databaseTemp, commands); err != nil {
+			sendError(server, request.SessionID, err)
 			return
 		}
 
+		fill := queryCache.fill(databaseTemp, request.Response.Data, commands)
 		commandsArray := make([]common.Command, 0)
 
-		for _, command := range commands {
+		for i, command := range commands {
 			var function func(interface{}, error)
 			switch command.(type) {
 			case *common.CreateTableCommand:
 				function = func(result interface{}, err error) {
 					if err != nil {
-						server.Send(request.SessionID, network.Response{Type: network.Error, Data: err.Error()})
+						sendError(server, request.SessionID, wrapError("CommandFailed", classInternal, err))
 						return
 					}
 					server.Send(request.SessionID, network.Response{Type: network.Notification, Data: "Table Created"})
@@ -213,7 +977,7 @@ func handleRequest(server *network.Server, request network.Request) {
 			case *common.DeleteCommand:
 				function = func(result interface{}, err error) {
 					if err != nil {
-						server.Send(request.SessionID, network.Response{Type: network.Error, Data: err.Error()})
+						sendError(server, request.SessionID, wrapError("CommandFailed", classInternal, err))
 						return
 					}
 					server.Send(request.SessionID, network.Response{Type: network.Notification, Data: "Data Deleted"})
@@ -221,7 +985,7 @@ func handleRequest(server *network.Server, request network.Request) {
 			case *common.InsertCommand:
 				function = func(result interface{}, err error) {
 					if err != nil {
-						server.Send(request.SessionID, network.Response{Type: network.Error, Data: err.Error()})
+						sendError(server, request.SessionID, wrapError("CommandFailed", classInternal, err))
 						return
 					}
 					server.Send(request.SessionID, network.Response{Type: network.Notification, Data: "Data Inserted"})
@@ -229,57 +993,75 @@ func handleRequest(server *network.Server, request network.Request) {
 			case *common.UpdateTableCommand:
 				function = func(result interface{}, err error) {
 					if err != nil {
-						server.Send(request.SessionID, network.Response{Type: network.Error, Data: err.Error()})
+						sendError(server, request.SessionID, wrapError("CommandFailed", classInternal, err))
 						return
 					}
 					server.Send(request.SessionID, network.Response{Type: network.Notification, Data: "Data Updated"})
 				}
 			case *common.SelectTableCommand:
+				index, start := i, time.Now()
 				function = func(result interface{}, err error) {
+					fill.record(index, result, err)
 					if err != nil {
-						server.Send(request.SessionID, network.Response{Type: network.Error, Data: err.Error()})
+						sendError(server, request.SessionID, wrapError("CommandFailed", classInternal, err))
 						return
 					}
 
-					resultJSON, _ := json.Marshal(result)
+					resultJSON, _ := json.Marshal(newQueryResult(result, time.Since(start)))
 					server.Send(request.SessionID, network.Response{Type: network.Query, Data: string(resultJSON)})
 				}
 			case *common.DropCommand:
 				function = func(result interface{}, err error) {
 					if err != nil {
-						server.Send(request.SessionID, network.Response{Type: network.Error, Data: err.Error()})
+						sendError(server, request.SessionID, wrapError("CommandFailed", classInternal, err))
 						return
 					}
 					server.Send(request.SessionID, network.Response{Type: network.Notification, Data: "Table Dropped"})
 				}
+			default:
+				function = func(result interface{}, err error) {
+					if err != nil {
+						sendError(server, request.SessionID, wrapError("CommandFailed", classInternal, err))
+						return
+					}
+					server.Send(request.SessionID, network.Response{Type: network.Notification, Data: "OK"})
+				}
 			}
-			commandsArray = append(commandsArray, databaseTemp.CommandFactory(command, function))
+			commandsArray = append(commandsArray, commandExecutor.Prepare(databaseTemp, command, function))
 
 		}
 
 		transaction.AddCommands(commandsArray)
 
 	case network.ShowTransaction:
-		transactions := transaction.GetTransactions()
-		transactionsJSON, err := json.Marshal(transactions)
+		transactionsJSON, err := json.Marshal(transactionsResponse{Transactions: transaction.GetTransactions()})
 		if err != nil {
 			log.Println(err)
+			sendError(server, request.SessionID, err)
+			return
 		}
-		server.Send(request.SessionID, network.Response{Type: network.ShowTransaction, Data: "{Transactions:" + string(transactionsJSON) + "}"})
+		server.Send(request.SessionID, network.Response{Type: network.ShowTransaction, Data: string(transactionsJSON)})
 	case network.Error:
 	case network.SessionExited:
+		sessionActivity.Delete(request.SessionID)
 		err := dbmanager.unpair(request.SessionID)
 		if err != nil {
 			log.Println(err)
 			return
 		}
 	case network.DropDb:
-		err := dbmanager.deleteDatabase(request.Response.Data, settings.Root)
+		name, force := parseDropPayload(request.Response.Data)
+		unpaired, err := dbmanager.deleteDatabase(name, force, request.SessionID)
 		if err != nil {
-			server.Send(request.SessionID, network.Response{Type: network.Error, Data: err.Error()})
+			sendError(server, request.SessionID, err)
 			return
 		}
-		server.Send(request.SessionID, network.Response{Type: network.Notification, Data: "Database " + request.Response.Data + " deleted."})
+		for _, sessionID := range unpaired {
+			if sessionID != request.SessionID {
+				server.Send(sessionID, network.Response{Type: network.Notification, Data: "Database " + name + " was deleted by another session."})
+			}
+		}
+		server.Send(request.SessionID, network.Response{Type: network.Notification, Data: "Database " + name + " deleted."})
 	}
 
 }
@@ -294,16 +1076,64 @@ func init() {
 }
 
 func main() {
+	scriptPath := flag.String("script", "", "run the SQL script at this path and exit")
+	scriptDatabase := flag.String("database", "", "database the script runs against")
+	force := flag.Bool("force", false, "keep running the script after a statement fails")
+	flag.Parse()
+
+	if err := validateBlockSize(settings.BlockSize); err != nil {
+		fmt.Println("Invalid BlockSize setting.", err)
+		os.Exit(1)
+	}
+
+	dbmanager.readOnly = settings.ReadOnly
+	dbmanager.lazyLoad = settings.LazyLoad
+	dbmanager.maxOpen = settings.MaxOpenDatabases
+	dbmanager.readOnlyDatabases = make(map[string]bool)
+	for _, name := range settings.ReadOnlyDatabases {
+		dbmanager.readOnlyDatabases[name] = true
+	}
+	if settings.ReadOnly {
+		log.Println("Running in read-only mode")
+	}
+
 	log.Println("Loading Databases")
-	err := dbmanager.loadAllDatabases(settings.Root)
-	if err != nil {
-		log.Println("Error loading databses. Exiting", err)
+	for _, root := range settings.roots() {
+		err := dbmanager.loadAllDatabases(root)
+		if err != nil {
+			log.Println("Error loading databses from", root, "Exiting", err)
+			return
+		}
+	}
+
+	if *scriptPath != "" {
+		failed, err := runScript(*scriptPath, *scriptDatabase, *force)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
 		return
 	}
 
+	if settings.WatchRoot {
+		for _, root := range settings.roots() {
+			err := watchDatabases(root)
+			if err != nil {
+				log.Println("Error watching databases directory", root, err)
+			}
+		}
+	}
+
 	log.Println("Starting server")
 	server := network.NewServer()
 
+	if settings.SessionIdleTimeout > 0 {
+		go expireIdleSessions(server, time.Duration(settings.SessionIdleTimeout)*time.Second)
+	}
+
 	go func() {
 		for {
 			select {
@@ -313,11 +1143,37 @@ func main() {
 		}
 	}()
 
+	tlsConfig, err := loadTLSConfig(settings)
+	if err != nil {
+		log.Println("Error loading TLS configuration. Exiting.", err)
+		os.Exit(1)
+	}
+
 	listener, err := net.Listen("tcp", settings.Host+":"+settings.Port)
 	if err != nil {
 		log.Println("Server Listener failed. Exiting.", err)
 		os.Exit(1)
 	}
+	if tlsConfig != nil {
+		log.Println("TLS enabled")
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	if settings.HTTPPort != "" {
+		go func() {
+			log.Println("Starting HTTP server")
+			err := startHTTPServer(settings.Host+":"+settings.HTTPPort, tlsConfig)
+			log.Println("HTTP server failed.", err)
+		}()
+	}
+
+	if settings.WebSocketPort != "" {
+		go func() {
+			log.Println("Starting WebSocket server")
+			err := startWebSocketServer(settings.Host+":"+settings.WebSocketPort, tlsConfig, server)
+			log.Println("WebSocket server failed.", err)
+		}()
+	}
 
 	for {
 		if settings.MaxSessions > server.GetSessionsAmount() {
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateDatabaseName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"sales", true},
+		{"sales_2017-v1.db", true},
+		{strings.Repeat("a", maxDatabaseNameLength), true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../etc", false},
+		{"a/b", false},
+		{`a\b`, false},
+		{"/abs", false},
+		{".hidden", false},
+		{strings.Repeat("a", maxDatabaseNameLength+1), false},
+		{"sales" + droppedSuffix, false},
+		{"sales:4096", false},
+		{"sales db", false},
+		{"ventasñ", false},
+	}
+	for _, test := range tests {
+		err := validateDatabaseName(test.name)
+		if test.valid && err != nil {
+			t.Errorf("validateDatabaseName(%q) = %v, want nil", test.name, err)
+		}
+		if !test.valid && err != errInvalidDatabaseName {
+			t.Errorf("validateDatabaseName(%q) = %v, want %v", test.name, err, errInvalidDatabaseName)
+		}
+	}
+}
+
+func TestDatabasePath(t *testing.T) {
+	root, err := ioutil.TempDir("", "modest-sql-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	outside, err := ioutil.TempDir("", "modest-sql-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	if err := ioutil.WriteFile(filepath.Join(root, "existing"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "ventas ñ"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(outside, "secret"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "directory"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+		err  error
+	}{
+		{"new", filepath.Join(root, "new"), nil},
+		{"existing", filepath.Join(root, "existing"), nil},
+		{"ventas ñ", filepath.Join(root, "ventas ñ"), nil},
+		{"sales" + droppedSuffix, "", errInvalidDatabaseName},
+		{"link", "", errInvalidDatabaseName},
+		{"directory", "", errInvalidDatabaseName},
+		{"..", "", errInvalidDatabaseName},
+		{"../secret", "", errInvalidDatabaseName},
+		{"directory/nested", "", errInvalidDatabaseName},
+	}
+	for _, test := range tests {
+		path, err := databasePath(root, test.name)
+		if path != test.want || err != test.err {
+			t.Errorf("databasePath(root, %q) = %q, %v, want %q, %v", test.name, path, err, test.want, test.err)
+		}
+	}
+}
+
+func TestParseNewDatabasePayload(t *testing.T) {
+	const defaultBlockSize = 4096
+	tests := []struct {
+		payload    string
+		name       string
+		blockSize  int64
+		tablespace string
+		valid      bool
+	}{
+		{"sales", "sales", defaultBlockSize, "", true},
+		{"sales:1024", "sales", 1024, "", true},
+		{"sales:", "sales", defaultBlockSize, "", true},
+		{"sales:1024:fast", "sales", 1024, "fast", true},
+		{"sales::fast", "sales", defaultBlockSize, "fast", true},
+		{"sales:big:fast", "", 0, "", false},
+		{"sales:big", "", 0, "", false},
+		{"sales:1000", "", 0, "", false},
+		{"sales:256", "", 0, "", false},
+		{"sales:131072", "", 0, "", false},
+	}
+	for _, test := range tests {
+		name, blockSize, tablespace, err := parseNewDatabasePayload(test.payload, defaultBlockSize)
+		if (err == nil) != test.valid {
+			t.Errorf("parseNewDatabasePayload(%q) error = %v, want valid %v", test.payload, err, test.valid)
+			continue
+		}
+		if name != test.name || blockSize != test.blockSize || tablespace != test.tablespace {
+			t.Errorf("parseNewDatabasePayload(%q) = %q, %d, %q, want %q, %d, %q",
+				test.payload, name, blockSize, tablespace, test.name, test.blockSize, test.tablespace)
+		}
+	}
+}
+
+func TestParseDropPayload(t *testing.T) {
+	tests := []struct {
+		payload string
+		name    string
+		force   bool
+	}{
+		{"sales", "sales", false},
+		{"sales:force", "sales", true},
+		{"sales:readonly", "sales:readonly", false},
+	}
+	for _, test := range tests {
+		name, force := parseDropPayload(test.payload)
+		if name != test.name || force != test.force {
+			t.Errorf("parseDropPayload(%q) = %q, %v, want %q, %v", test.payload, name, force, test.name, test.force)
+		}
+	}
+}
+
+func TestParseLoadPayload(t *testing.T) {
+	tests := []struct {
+		payload  string
+		name     string
+		readOnly bool
+	}{
+		{"sales", "sales", false},
+		{"sales:readonly", "sales", true},
+		{"sales:force", "sales:force", false},
+	}
+	for _, test := range tests {
+		name, readOnly := parseLoadPayload(test.payload)
+		if name != test.name || readOnly != test.readOnly {
+			t.Errorf("parseLoadPayload(%q) = %q, %v, want %q, %v", test.payload, name, readOnly, test.name, test.readOnly)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/modest-sql/parser"
+)
+
+//runScript runs the SQL script at path against the named database one statement at a time, stopping
+//at the first failed statement unless force is set. It returns the number of statements that failed.
+func runScript(path string, databaseName string, force bool) (int, error) {
+	db, err := dbmanager.acquire(databaseName)
+	if err != nil {
+		return 0, err
+	}
+	defer dbmanager.release(databaseName)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	commands, err := parser.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return 0, wrapError("SyntaxError", classSyntaxOrAccess, err)
+	}
+	if err := dbmanager.checkWritable(noSession, db, commands); err != nil {
+		return 0, err
+	}
+
+	failed := 0
+	for i, command := range commands {
+		result := executeCommand(db, command)
+		if result.Error != nil {
+			failed++
+			fmt.Printf("Statement %d: ERROR %s (%s): %s\n", i+1, result.Error.Code, result.Error.Class, result.Error.Message)
+			if !force {
+				fmt.Printf("Stopped after %d of %d statements\n", i+1, len(commands))
+				return failed, nil
+			}
+			continue
+		}
+		if result.Result != nil {
+			fmt.Printf("Statement %d: OK, %d rows\n", i+1, result.Result.RowCount)
+		} else {
+			fmt.Printf("Statement %d: OK\n", i+1)
+		}
+	}
+
+	fmt.Printf("Ran %d statements, %d failed\n", len(commands), failed)
+	return failed, nil
+}
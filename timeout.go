@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/modest-sql/network"
+)
+
+//sessionActivity maps a session ID to the time of its last request
+var sessionActivity sync.Map
+
+//touchSession records activity for a session
+func touchSession(sessionID int64) {
+	sessionActivity.Store(sessionID, time.Now())
+}
+
+//expireIdleSessions periodically unpairs sessions that haven't sent a request within timeout and notifies them
+func expireIdleSessions(server *network.Server, timeout time.Duration) {
+	for range time.Tick(timeout / 2) {
+		sessionActivity.Range(func(ki, vi interface{}) bool {
+			k, v := ki.(int64), vi.(time.Time)
+			if time.Since(v) > timeout {
+				sessionActivity.Delete(k)
+				if dbmanager.unpair(k) == nil {
+					log.Println("Session", k, "idle, unpairing")
+					server.Send(k, network.Response{Type: network.Notification, Data: "Session was idle for " + timeout.String() + ", its database was unpaired. Load a database to continue."})
+				}
+			}
+			return true
+		})
+	}
+}
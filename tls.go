@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+//loadTLSConfig builds the listener TLS configuration from the settings, returning nil when TLS is disabled
+func loadTLSConfig(c config) (*tls.Config, error) {
+	if c.TLSCertFile == "" && c.TLSKeyFile == "" {
+		if c.RequireTLS {
+			return nil, errors.New("RequireTLS is set but no certificate was configured")
+		}
+		return nil, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{certificate}}
+
+	//Client certificates signed by the configured CA are required when one is provided
+	if c.TLSClientCAFile != "" {
+		raw, err := ioutil.ReadFile(c.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(raw) {
+			return nil, errors.New("No valid certificates found in " + c.TLSClientCAFile)
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+//watchInterval is how often a watched root is checked for changed database files
+const watchInterval = 2 * time.Second
+
+//fileState is what the watcher compares between checks to tell whether a file is still being written
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+//snapshotDatabases records the state of every database file in path
+func snapshotDatabases(path string) (map[string]fileState, error) {
+	databasesFiles, err := listDatabases(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := make(map[string]fileState, len(databasesFiles))
+	for _, databaseFile := range databasesFiles {
+		snapshot[databaseFile.Name()] = fileState{size: databaseFile.Size(), modTime: databaseFile.ModTime()}
+	}
+	return snapshot, nil
+}
+
+func sameSnapshot(a map[string]fileState, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, state := range a {
+		other, ok := b[name]
+		if !ok || other.size != state.size || !other.modTime.Equal(state.modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+//watchDatabases polls path and rescans it once its files have stopped changing for a whole interval, so a file
+//still being copied into path isn't loaded while partial. A file that fails to load is retried when it changes again.
+//Moving a complete file into path is still the safest way to add a database, since a copy that stalls for longer
+//than the interval is picked up as it is.
+func watchDatabases(path string) error {
+	previous, err := snapshotDatabases(path)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		pending := false
+		for range time.Tick(watchInterval) {
+			current, err := snapshotDatabases(path)
+			if err != nil {
+				log.Println("Database watcher error:", err)
+				continue
+			}
+			if !sameSnapshot(previous, current) {
+				previous, pending = current, true
+				continue
+			}
+			if !pending {
+				continue
+			}
+			if err := dbmanager.rescan(path); err != nil {
+				log.Println("Error rescanning databases:", err)
+				continue
+			}
+			pending = false
+		}
+	}()
+	return nil
+}
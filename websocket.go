@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/modest-sql/network"
+)
+
+//websocketGUID is appended to the client's key to compute the handshake's accept value (RFC 6455 section 1.3)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+//WebSocket frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+//maxControlPayload is the largest payload RFC 6455 allows in a close, ping or pong frame
+const maxControlPayload = 125
+
+var errUnmaskedFrame = errors.New("websocket: client sent an unmasked frame")
+
+//websocketConn lets a session use a WebSocket like a TCP connection. Reads return the payload of the
+//data frames received as a stream and every write is sent as a single binary frame.
+type websocketConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	//remaining counts the payload bytes of the current data frame that haven't been read yet
+	remaining  uint64
+	mask       [4]byte
+	maskOffset int
+
+	writeMutex sync.Mutex
+	closeOnce  sync.Once
+}
+
+//acceptWebSocket completes the opening handshake of r and takes over its connection
+func acceptWebSocket(w http.ResponseWriter, r *http.Request) (*websocketConn, error) {
+	if r.Method != http.MethodGet || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || !headerHasToken(r.Header, "Connection", "upgrade") {
+		http.Error(w, "WebSocket upgrade required", http.StatusBadRequest)
+		return nil, errors.New("websocket: not an upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket: missing key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported on this connection", http.StatusInternalServerError)
+		return nil, errors.New("websocket: connection can't be hijacked")
+	}
+
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	digest := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(digest[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &websocketConn{Conn: conn, reader: buffered.Reader}, nil
+}
+
+//checkOrigin accepts handshakes without an Origin header, which only browsers send, and browser handshakes from
+//pages served by the engine's own host or listed in WebSocketOrigins, so other sites can't open a session
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range settings.WebSocketOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Host, r.Host)
+}
+
+//headerHasToken reports whether the comma separated header name contains token, ignoring case
+func headerHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *websocketConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= c.mask[(c.maskOffset+i)%4]
+	}
+	c.maskOffset = (c.maskOffset + n) % 4
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+//nextFrame reads frame headers until a data frame starts, answering the control frames found on the way
+func (c *websocketConn) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return err
+	}
+	opcode := header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		c.Close()
+		return errUnmaskedFrame
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return err
+	}
+
+	switch opcode {
+	case opContinuation, opText, opBinary:
+		c.remaining, c.mask, c.maskOffset = length, mask, 0
+		return nil
+	case opClose, opPing, opPong:
+		if length > maxControlPayload {
+			c.Close()
+			return errors.New("websocket: control frame too long")
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+		if opcode == opClose {
+			c.Close()
+			return io.EOF
+		}
+		if opcode == opPing {
+			return c.writeFrame(opPong, payload)
+		}
+		return nil
+	default:
+		c.Close()
+		return errors.New("websocket: unknown opcode")
+	}
+}
+
+//writeFrame sends a single unmasked frame, as servers must
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|opcode)
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		var extended [8]byte
+		binary.BigEndian.PutUint64(extended[:], uint64(len(payload)))
+		frame = append(append(frame, 127), extended[:]...)
+	}
+	frame = append(frame, payload...)
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+func (c *websocketConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+//Close sends a close frame and closes the connection
+func (c *websocketConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.writeFrame(opClose, nil)
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+//startWebSocketServer accepts WebSocket connections on address and joins them to server as regular sessions
+func startWebSocketServer(address string, tlsConfig *tls.Config, server *network.Server) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if settings.MaxSessions <= server.GetSessionsAmount() {
+			http.Error(w, "Too many sessions", http.StatusServiceUnavailable)
+			return
+		}
+		if !checkOrigin(r) {
+			log.Println("WebSocket handshake from origin", r.Header.Get("Origin"), "rejected.")
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		conn, err := acceptWebSocket(w, r)
+		if err != nil {
+			log.Println("WebSocket handshake failed.", err)
+			return
+		}
+		log.Println("A new WebSocket connection accepted.")
+		server.Join(conn)
+	})
+
+	httpServer := &http.Server{Addr: address, Handler: mux, TLSConfig: tlsConfig}
+	if tlsConfig != nil {
+		return httpServer.ListenAndServeTLS("", "")
+	}
+	return httpServer.ListenAndServe()
+}
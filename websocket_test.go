@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+var testMask = [4]byte{1, 2, 3, 4}
+
+//clientFrame builds a frame the way a client must send it, masked
+func clientFrame(opcode byte, final bool, payload []byte) []byte {
+	var frame []byte
+	if final {
+		frame = append(frame, 0x80|opcode)
+	} else {
+		frame = append(frame, opcode)
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 0x80|126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		var extended [8]byte
+		binary.BigEndian.PutUint64(extended[:], uint64(len(payload)))
+		frame = append(append(frame, 0x80|127), extended[:]...)
+	}
+	frame = append(frame, testMask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^testMask[i%4])
+	}
+	return frame
+}
+
+type serverFrame struct {
+	opcode  byte
+	payload []byte
+}
+
+//readServerFrame parses an unmasked frame sent by the server
+func readServerFrame(r io.Reader) (serverFrame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return serverFrame{}, err
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(r, extended[:]); err != nil {
+			return serverFrame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(r, extended[:]); err != nil {
+			return serverFrame{}, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return serverFrame{}, err
+	}
+	return serverFrame{opcode: header[0] & 0x0F, payload: payload}, nil
+}
+
+//pipeWebSocket connects a websocketConn to a client end that sends frames and collects the server's frames.
+//The returned channel is closed once the server closes its end.
+func pipeWebSocket(frames ...[]byte) (*websocketConn, <-chan serverFrame) {
+	server, client := net.Pipe()
+	go func() {
+		for _, frame := range frames {
+			if _, err := client.Write(frame); err != nil {
+				return
+			}
+		}
+	}()
+	received := make(chan serverFrame, 16)
+	go func() {
+		defer close(received)
+		defer client.Close()
+		for {
+			frame, err := readServerFrame(client)
+			if err != nil {
+				return
+			}
+			received <- frame
+		}
+	}()
+	return &websocketConn{Conn: server, reader: bufio.NewReader(server)}, received
+}
+
+//readAll reads n payload bytes from conn using a small buffer, so reads split frames and their masks
+func readAll(conn *websocketConn, n int) ([]byte, error) {
+	var data []byte
+	buffer := make([]byte, 7)
+	for len(data) < n {
+		read, err := conn.Read(buffer)
+		data = append(data, buffer[:read]...)
+		if err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+func payloadOf(size int) []byte {
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+	return payload
+}
+
+func TestWebSocketRead(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+		want   []byte
+	}{
+		{"short", [][]byte{clientFrame(opBinary, true, []byte("hello"))}, []byte("hello")},
+		{"16-bit length", [][]byte{clientFrame(opText, true, payloadOf(200))}, payloadOf(200)},
+		{"64-bit length", [][]byte{clientFrame(opBinary, true, payloadOf(70000))}, payloadOf(70000)},
+		{"fragmented", [][]byte{
+			clientFrame(opText, false, []byte("SELECT ")),
+			clientFrame(opContinuation, true, []byte("a FROM t;")),
+		}, []byte("SELECT a FROM t;")},
+		{"split header", [][]byte{
+			clientFrame(opBinary, true, payloadOf(300))[:3],
+			clientFrame(opBinary, true, payloadOf(300))[3:],
+		}, payloadOf(300)},
+	}
+	for _, test := range tests {
+		conn, _ := pipeWebSocket(test.frames...)
+		got, err := readAll(conn, len(test.want))
+		if err != nil {
+			t.Errorf("%s: Read error = %v", test.name, err)
+		} else if !bytes.Equal(got, test.want) {
+			t.Errorf("%s: Read = %d bytes that differ from the %d sent", test.name, len(got), len(test.want))
+		}
+		conn.Conn.Close()
+	}
+}
+
+func TestWebSocketControlFrames(t *testing.T) {
+	conn, received := pipeWebSocket(
+		clientFrame(opPing, true, []byte("ping")),
+		clientFrame(opPong, true, []byte("ignored")),
+		clientFrame(opBinary, true, []byte("data")),
+		clientFrame(opClose, true, nil),
+	)
+	got, err := readAll(conn, 4)
+	if err != nil || string(got) != "data" {
+		t.Fatalf("Read = %q, %v, want %q, nil", got, err, "data")
+	}
+	if frame := <-received; frame.opcode != opPong || string(frame.payload) != "ping" {
+		t.Errorf("reply to ping = opcode %#x %q, want pong %q", frame.opcode, frame.payload, "ping")
+	}
+
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read after close frame error = %v, want %v", err, io.EOF)
+	}
+	if frame := <-received; frame.opcode != opClose {
+		t.Errorf("reply to close = opcode %#x, want close", frame.opcode)
+	}
+	if _, open := <-received; open {
+		t.Error("connection still open after close frame")
+	}
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	unmasked := []byte{0x80 | opBinary, 2, 'h', 'i'}
+	tests := []struct {
+		name  string
+		frame []byte
+		err   error
+	}{
+		{"unmasked", unmasked, errUnmaskedFrame},
+		{"long ping", clientFrame(opPing, true, payloadOf(maxControlPayload+1)), nil},
+		{"long close", clientFrame(opClose, true, payloadOf(maxControlPayload+1)), nil},
+		{"unknown opcode", clientFrame(0x3, true, nil), nil},
+	}
+	for _, test := range tests {
+		conn, received := pipeWebSocket(test.frame)
+		_, err := conn.Read(make([]byte, 16))
+		if err == nil || err == io.EOF || test.err != nil && err != test.err {
+			t.Errorf("%s: Read error = %v, want a protocol error", test.name, err)
+		}
+		if frame := <-received; frame.opcode != opClose {
+			t.Errorf("%s: server sent opcode %#x, want close", test.name, frame.opcode)
+		}
+	}
+}
+
+func TestWebSocketWrite(t *testing.T) {
+	for _, size := range []int{0, 5, 125, 126, 300, 0xFFFF, 70000} {
+		conn, received := pipeWebSocket()
+		payload := payloadOf(size)
+		if n, err := conn.Write(payload); n != size || err != nil {
+			t.Errorf("Write(%d bytes) = %d, %v, want %d, nil", size, n, err, size)
+		}
+		frame := <-received
+		if frame.opcode != opBinary || !bytes.Equal(frame.payload, payload) {
+			t.Errorf("Write(%d bytes) sent opcode %#x with %d bytes, want a binary frame with the payload", size, frame.opcode, len(frame.payload))
+		}
+		conn.Conn.Close()
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	allowed := settings.WebSocketOrigins
+	defer func() { settings.WebSocketOrigins = allowed }()
+	settings.WebSocketOrigins = []string{"https://console.example.com"}
+
+	tests := []struct {
+		host   string
+		origin string
+		want   bool
+	}{
+		{"db.example.com:8081", "", true},
+		{"db.example.com:8081", "http://db.example.com:8081", true},
+		{"db.example.com:8081", "HTTPS://DB.EXAMPLE.COM:8081", true},
+		{"db.example.com:8081", "https://console.example.com", true},
+		{"db.example.com:8081", "https://CONSOLE.example.com", true},
+		{"db.example.com:8081", "https://evil.example.com", false},
+		{"db.example.com:8081", "http://db.example.com:9000", false},
+		{"db.example.com:8081", "https://console.example.com.evil.com", false},
+		{"db.example.com:8081", "null", false},
+		{"db.example.com:8081", "://bad", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = test.host
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if got := checkOrigin(r); got != test.want {
+			t.Errorf("checkOrigin(Host %q, Origin %q) = %v, want %v", test.host, test.origin, got, test.want)
+		}
+	}
+}